@@ -0,0 +1,78 @@
+package auth0
+
+import (
+	"strings"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// AudiencePolicy defines how the audiences of a token are
+// matched against the audiences of the configuration.
+type AudiencePolicy int
+
+const (
+	// AudienceAllOf requires every audience of the configuration
+	// to be present in the token. This is the default policy.
+	AudienceAllOf AudiencePolicy = iota
+	// AudienceAnyOf requires at least one audience
+	// of the configuration to be present in the token.
+	AudienceAnyOf
+	// AudiencePattern requires at least one audience of the token to match
+	// one of the audiences of the configuration, where * matches any
+	// sequence of characters, such as "https://api.example.com/*".
+	AudiencePattern
+)
+
+// validate checks the audience of the token against the expected ones.
+// An empty list of expected audiences accepts any token.
+func (p AudiencePolicy) validate(expected []string, audience jwt.Audience) error {
+	if len(expected) == 0 {
+		return nil
+	}
+
+	switch p {
+	case AudienceAnyOf:
+		for _, aud := range expected {
+			if audience.Contains(aud) {
+				return nil
+			}
+		}
+	case AudiencePattern:
+		for _, pattern := range expected {
+			for _, aud := range audience {
+				if matchPattern(pattern, aud) {
+					return nil
+				}
+			}
+		}
+	default:
+		for _, aud := range expected {
+			if !audience.Contains(aud) {
+				return jwt.ErrInvalidAudience
+			}
+		}
+		return nil
+	}
+	return jwt.ErrInvalidAudience
+}
+
+// matchPattern reports whether s matches pattern,
+// where * matches any sequence of characters.
+func matchPattern(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,83 @@
+package auth0
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestAudiencePolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        AudiencePolicy
+		expected      []string
+		aud           interface{}
+		expectedError bool
+	}{
+		{name: "all of - string", policy: AudienceAllOf, expected: []string{"api"}, aud: "api"},
+		{name: "all of - array", policy: AudienceAllOf, expected: []string{"api", "other"}, aud: []string{"other", "api"}},
+		{name: "all of - missing one", policy: AudienceAllOf, expected: []string{"api", "other"}, aud: []string{"api"}, expectedError: true},
+		{name: "all of - no expected audience", policy: AudienceAllOf, aud: "api"},
+		{name: "any of - string", policy: AudienceAnyOf, expected: []string{"api", "other"}, aud: "other"},
+		{name: "any of - array", policy: AudienceAnyOf, expected: []string{"api", "other"}, aud: []string{"userinfo", "api"}},
+		{name: "any of - none", policy: AudienceAnyOf, expected: []string{"api", "other"}, aud: []string{"userinfo"}, expectedError: true},
+		{name: "any of - no audience in token", policy: AudienceAnyOf, expected: []string{"api"}, expectedError: true},
+		{name: "pattern - prefix string", policy: AudiencePattern, expected: []string{"https://api.example.com/*"}, aud: "https://api.example.com/v1/orders"},
+		{name: "pattern - glob array", policy: AudiencePattern, expected: []string{"https://*.example.com/api"}, aud: []string{"userinfo", "https://eu.example.com/api"}},
+		{name: "pattern - exact", policy: AudiencePattern, expected: []string{"api"}, aud: "api"},
+		{name: "pattern - no match", policy: AudiencePattern, expected: []string{"https://api.example.com/*"}, aud: []string{"https://evil.com/https://api.example.com/"}, expectedError: true},
+		{name: "pattern - suffix mismatch", policy: AudiencePattern, expected: []string{"https://*.example.com/api"}, aud: "https://eu.example.com/api/admin", expectedError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := map[string]interface{}{
+				"iss": defaultIssuer,
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}
+			if test.aud != nil {
+				claims["aud"] = test.aud
+			}
+			token, err := jwt.ParseSigned(getTestTokenWithClaims(claims, jose.HS256, defaultSecret))
+			assert.Nil(t, err)
+
+			configuration := NewConfigurationWithOptions(defaultSecretProvider,
+				WithAudience(test.expected...),
+				WithIssuer(defaultIssuer),
+				WithAudiencePolicy(test.policy),
+			)
+			err = NewValidator(configuration, nil).ValidateToken(token)
+			if test.expectedError {
+				assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonWrongAudience}), "unexpected error: %v", err)
+				assert.True(t, errors.Is(err, jwt.ErrInvalidAudience))
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"api", "api", true},
+		{"api", "api2", false},
+		{"*", "anything", true},
+		{"api*", "api", true},
+		{"*api", "my-api", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXcYb", false},
+		{"a*a", "a", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.match, matchPattern(test.pattern, test.s), "%q against %q", test.s, test.pattern)
+	}
+}
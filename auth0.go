@@ -1,8 +1,14 @@
 package auth0
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"gopkg.in/square/go-jose.v2"
@@ -24,6 +30,38 @@ func (f SecretProviderFunc) GetSecret(token *jwt.JSONWebToken) (interface{}, err
 	return f(token)
 }
 
+// ContextSecretProvider is a SecretProvider honouring the context
+// of the validation, such as the context of the http request, to
+// cancel the retrieval of the secret.
+type ContextSecretProvider interface {
+	SecretProvider
+	GetSecretContext(ctx context.Context, token *jwt.JSONWebToken) (interface{}, error)
+}
+
+// ContextSecretProviderFunc simple wrapper to provide
+// secret with functions honouring the context.
+type ContextSecretProviderFunc func(ctx context.Context, token *jwt.JSONWebToken) (interface{}, error)
+
+// GetSecret implements the SecretProvider interface
+// with the background context.
+func (f ContextSecretProviderFunc) GetSecret(token *jwt.JSONWebToken) (interface{}, error) {
+	return f(context.Background(), token)
+}
+
+// GetSecretContext implements the ContextSecretProvider interface.
+func (f ContextSecretProviderFunc) GetSecretContext(ctx context.Context, token *jwt.JSONWebToken) (interface{}, error) {
+	return f(ctx, token)
+}
+
+// getSecret retrieves the secret of the token with ctx
+// when the provider is a ContextSecretProvider.
+func getSecret(ctx context.Context, provider SecretProvider, token *jwt.JSONWebToken) (interface{}, error) {
+	if p, ok := provider.(ContextSecretProvider); ok {
+		return p.GetSecretContext(ctx, token)
+	}
+	return provider.GetSecret(token)
+}
+
 // NewKeyProvider provide a simple passphrase key provider.
 func NewKeyProvider(key interface{}) SecretProvider {
 	return SecretProviderFunc(func(_ *jwt.JSONWebToken) (interface{}, error) {
@@ -34,6 +72,27 @@ func NewKeyProvider(key interface{}) SecretProvider {
 var (
 	// ErrNoJWTHeaders is returned when there are no headers in the JWT.
 	ErrNoJWTHeaders = errors.New("No headers in the token")
+	// ErrInvalidKeyType is returned when the key provided for a token
+	// cannot be used with the algorithm of the token.
+	ErrInvalidKeyType = errors.New("key type does not match the algorithm")
+	// ErrMissingRequiredClaim is returned when a claim
+	// required by the configuration is absent from the token.
+	ErrMissingRequiredClaim = errors.New("required claim is missing")
+	// ErrMissingExpiry is returned when the configuration
+	// requires an exp claim and the token has none.
+	ErrMissingExpiry = errors.New("token has no expiry (exp)")
+	// ErrMissingIssuedAt is returned when the configuration limits
+	// the age or the lifetime of the tokens and the token has no iat claim.
+	ErrMissingIssuedAt = errors.New("token has no issue time (iat)")
+	// ErrTokenTooOld is returned when the token was issued
+	// longer ago than the maximum age of the configuration.
+	ErrTokenTooOld = errors.New("token issued too long ago (iat)")
+	// ErrLifetimeTooLong is returned when the lifetime of the token, from iat
+	// to exp, exceeds the maximum lifetime of the configuration.
+	ErrLifetimeTooLong = errors.New("token lifetime exceeds the maximum (exp - iat)")
+	// ErrIssuedInTheFuture is returned when the configuration rejects the
+	// tokens issued in the future and the iat claim of the token is.
+	ErrIssuedInTheFuture = errors.New("token issued in the future (iat)")
 )
 
 // Configuration contains
@@ -42,24 +101,124 @@ var (
 type Configuration struct {
 	secretProvider SecretProvider
 	expectedClaims jwt.Expected
-	signIn         jose.SignatureAlgorithm
+	signIn         []jose.SignatureAlgorithm
+	leeway         time.Duration
+	requiredClaims []string
+	clock          Clock
+	// claimsNamespace is stripped from the claim names
+	// when unmarshalling them into custom structs.
+	claimsNamespace      string
+	claimsValidators     []ClaimsValidator
+	audiencePolicy       AudiencePolicy
+	maxTokenAge          time.Duration
+	maxLifetime          time.Duration
+	requireExpiry        bool
+	rejectFutureIssuedAt bool
+	replayCache          ReplayCache
+	revokers             []Revoker
+	// decryptionKeyProvider decrypts the nested tokens
+	// found by the extractors of the package.
+	decryptionKeyProvider DecryptionKeyProvider
+	dpop                  *DPoPOptions
+	certificateBinding    bool
 }
 
 // NewConfiguration creates a configuration for server
 func NewConfiguration(provider SecretProvider, audience []string, issuer string, method jose.SignatureAlgorithm) Configuration {
-	return Configuration{
-		secretProvider: provider,
-		expectedClaims: jwt.Expected{Issuer: issuer, Audience: audience},
-		signIn:         method,
+	if method == "" {
+		return NewConfigurationTrustProvider(provider, audience, issuer)
 	}
+	return NewConfigurationWithAlgorithms(provider, audience, issuer, method)
+}
+
+// NewConfigurationWithAlgorithms creates a configuration for server
+// accepting tokens signed with any of the provided algorithms.
+func NewConfigurationWithAlgorithms(provider SecretProvider, audience []string, issuer string, algorithms ...jose.SignatureAlgorithm) Configuration {
+	return NewConfigurationWithOptions(provider, WithAudience(audience...), WithIssuer(issuer), WithAlgorithms(algorithms...))
 }
 
 // NewConfigurationTrustProvider creates a configuration for server with no enforcement for token sig alg type, instead trust provider
 func NewConfigurationTrustProvider(provider SecretProvider, audience []string, issuer string) Configuration {
-	return Configuration{
+	return NewConfigurationWithOptions(provider, WithAudience(audience...), WithIssuer(issuer))
+}
+
+// NewConfigurationWithOptions creates a configuration for server from
+// the provided options. Without any option, the signature and the expiry
+// of the tokens are validated with a leeway of one minute, and their
+// signing algorithm is not enforced.
+func NewConfigurationWithOptions(provider SecretProvider, opts ...ConfigOption) Configuration {
+	configuration := Configuration{
 		secretProvider: provider,
-		expectedClaims: jwt.Expected{Issuer: issuer, Audience: audience},
+		leeway:         jwt.DefaultLeeway,
+		clock:          systemClock,
+	}
+	for _, opt := range opts {
+		opt(&configuration)
 	}
+	if configuration.dpop != nil && configuration.dpop.ReplayCache == nil {
+		configuration.dpop.ReplayCache = NewMemoryReplayCacheWithClock(configuration.dpop.defaultReplayCacheSize(configuration.leeway), configuration.clock)
+	}
+	return configuration
+}
+
+func (c Configuration) allowsAlgorithm(alg string) bool {
+	for _, allowed := range c.signIn {
+		if string(allowed) == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTokenAge enforces the maximum age and lifetime of the tokens,
+// and rejects the tokens issued in the future when configured to.
+func (c Configuration) validateTokenAge(claims jwt.Claims, now time.Time, leeway time.Duration) error {
+	issuedAt := claims.IssuedAt.Time()
+	if c.rejectFutureIssuedAt && claims.IssuedAt != 0 && issuedAt.After(now.Add(leeway)) {
+		return ErrIssuedInTheFuture
+	}
+	if c.maxTokenAge <= 0 && c.maxLifetime <= 0 {
+		return nil
+	}
+	if claims.IssuedAt == 0 {
+		return ErrMissingIssuedAt
+	}
+
+	if c.maxTokenAge > 0 && now.Add(-leeway).Sub(issuedAt) > c.maxTokenAge {
+		return ErrTokenTooOld
+	}
+	if c.maxLifetime > 0 && claims.Expiry.Time().Sub(issuedAt) > c.maxLifetime {
+		return ErrLifetimeTooLong
+	}
+	return nil
+}
+
+// keyMatchesAlgorithm checks that key can verify tokens signed with alg.
+// Unknown key types, such as opaque verifiers, are left to go-jose.
+func keyMatchesAlgorithm(key interface{}, alg string) bool {
+	switch k := key.(type) {
+	case jose.JSONWebKey:
+		return jwkMatchesAlgorithm(&k, alg)
+	case *jose.JSONWebKey:
+		return jwkMatchesAlgorithm(k, alg)
+	case []byte:
+		return strings.HasPrefix(alg, "HS")
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey, *ecdsa.PrivateKey:
+		return strings.HasPrefix(alg, "ES")
+	}
+	return true
+}
+
+func jwkMatchesAlgorithm(key *jose.JSONWebKey, alg string) bool {
+	if key.Use != "" && key.Use != "sig" {
+		return false
+	}
+	if key.Algorithm != "" && key.Algorithm != alg {
+		return false
+	}
+	return keyMatchesAlgorithm(key.Key, alg)
 }
 
 // JWTValidator helps middleware
@@ -80,9 +239,11 @@ func NewValidator(config Configuration, extractor RequestTokenExtractor) *JWTVal
 
 // ValidateRequest validates the token within
 // the http request.
-// A default leeway value of one minute is used to compare time values.
+// The leeway of the configuration, one minute by default,
+// is used to compare time values, and the context of the
+// request to retrieve the secret.
 func (v *JWTValidator) ValidateRequest(r *http.Request) (*jwt.JSONWebToken, error) {
-	return v.validateRequestWithLeeway(r, jwt.DefaultLeeway)
+	return v.validateRequestWithLeeway(r, v.config.leeway)
 }
 
 // ValidateRequestWithLeeway validates the token within
@@ -93,52 +254,252 @@ func (v *JWTValidator) ValidateRequestWithLeeway(r *http.Request, leeway time.Du
 }
 
 func (v *JWTValidator) validateRequestWithLeeway(r *http.Request, leeway time.Duration) (*jwt.JSONWebToken, error) {
-	token, err := v.extractor.Extract(r)
+	validated, err := v.validateRequest(r, leeway)
+	if err != nil {
+		return nil, err
+	}
+	return validated.Token, nil
+}
+
+func (v *JWTValidator) validateRequest(r *http.Request, leeway time.Duration) (*ValidatedToken, error) {
+	token, err := v.extract(r)
+	if err != nil {
+		return nil, err
+	}
+	return v.validateRequestToken(r, token, leeway, nil)
+}
+
+// validateRequestToken validates the token extracted from the request,
+// unmarshalling its claims into custom if not nil, then checks its
+// binding to the request before recording its ID.
+func (v *JWTValidator) validateRequestToken(r *http.Request, token *jwt.JSONWebToken, leeway time.Duration, custom interface{}) (*ValidatedToken, error) {
+	validated, err := v.verifyToken(r.Context(), token, leeway, custom)
 	if err != nil {
 		return nil, err
 	}
+	if err = v.validateBinding(r, validated, leeway); err != nil {
+		return nil, err
+	}
+	if err = v.useTokenID(validated, leeway); err != nil {
+		return nil, err
+	}
+	return validated, nil
+}
 
-	if err := v.validateTokenWithLeeway(token, leeway); err != nil {
+// validateToken validates the token received outside a request,
+// unmarshalling its claims into custom if not nil, before recording its ID.
+func (v *JWTValidator) validateToken(ctx context.Context, token *jwt.JSONWebToken, leeway time.Duration, custom interface{}) (*ValidatedToken, error) {
+	validated, err := v.verifyToken(ctx, token, leeway, custom)
+	if err != nil {
 		return nil, err
 	}
+	if err = v.useTokenID(validated, leeway); err != nil {
+		return nil, err
+	}
+	return validated, nil
+}
+
+// verifyToken validates the token, unmarshalling its claims
+// into custom if not nil, without recording its ID.
+func (v *JWTValidator) verifyToken(ctx context.Context, token *jwt.JSONWebToken, leeway time.Duration, custom interface{}) (*ValidatedToken, error) {
+	if custom == nil {
+		return v.validateTokenWithLeeway(ctx, token, leeway)
+	}
+	return v.validateTokenClaims(ctx, token, custom)
+}
+
+// validateBinding checks that the request proves the possession
+// of the key the token is bound to, if required by the configuration.
+func (v *JWTValidator) validateBinding(r *http.Request, validated *ValidatedToken, leeway time.Duration) error {
+	if v.config.dpop != nil {
+		if err := v.config.dpop.verify(r, validated, v.config.clock.Now(), leeway); err != nil {
+			return newValidationError(ReasonInvalidDPoPProof, err)
+		}
+	} else if err := rejectDPoP(r, validated); err != nil {
+		return err
+	}
+	if v.config.certificateBinding {
+		if err := verifyCertificateBinding(r, validated); err != nil {
+			return newValidationError(ReasonCertificateMismatch, err)
+		}
+	}
+	return nil
+}
 
+// extract extracts the token from the request,
+// decrypting it if it is a nested token.
+func (v *JWTValidator) extract(r *http.Request) (*jwt.JSONWebToken, error) {
+	if v.config.decryptionKeyProvider != nil {
+		r = r.WithContext(withDecryptionKeyProvider(r.Context(), v.config.decryptionKeyProvider))
+	}
+	token, err := v.extractor.Extract(r)
+	if err != nil {
+		return nil, newValidationError(extractionReason(err), err)
+	}
 	return token, nil
 }
 
+// ValidateRequestClaims validates the token within the http request
+// and unmarshalls its claims into custom, verifying the signature once.
+// The leeway of the configuration is used to compare time values.
+func (v *JWTValidator) ValidateRequestClaims(r *http.Request, custom interface{}) (*ValidatedToken, error) {
+	token, err := v.extract(r)
+	if err != nil {
+		return nil, err
+	}
+	return v.validateRequestToken(r, token, v.config.leeway, custom)
+}
+
+// ValidateTokenClaims validates the token and unmarshalls its claims
+// into custom, verifying the signature once. Claims under the namespace
+// of the configuration can be unmarshalled without it, such as
+// "https://example.com/roles" into a field tagged `json:"roles"`.
+// The leeway of the configuration is used to compare time values.
+func (v *JWTValidator) ValidateTokenClaims(token *jwt.JSONWebToken, custom interface{}) (*ValidatedToken, error) {
+	return v.validateToken(context.Background(), token, v.config.leeway, custom)
+}
+
+func (v *JWTValidator) validateTokenClaims(ctx context.Context, token *jwt.JSONWebToken, custom interface{}) (*ValidatedToken, error) {
+	if v.config.claimsNamespace == "" {
+		return v.validateTokenWithLeeway(ctx, token, v.config.leeway, custom)
+	}
+
+	raw := map[string]json.RawMessage{}
+	validated, err := v.validateTokenWithLeeway(ctx, token, v.config.leeway, &raw)
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]json.RawMessage, len(raw))
+	for name, value := range raw {
+		claims[name] = value
+	}
+	// namespaced claims take precedence over the ones named the same without namespace
+	for name, value := range raw {
+		if strings.HasPrefix(name, v.config.claimsNamespace) {
+			claims[strings.TrimPrefix(name, v.config.claimsNamespace)] = value
+		}
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil, newValidationError(ReasonMalformed, err)
+	}
+	if err = json.Unmarshal(b, custom); err != nil {
+		return nil, newValidationError(ReasonMalformed, err)
+	}
+	return validated, nil
+}
+
 func (v *JWTValidator) ValidateToken(token *jwt.JSONWebToken) error {
-	return v.validateTokenWithLeeway(token, jwt.DefaultLeeway)
+	_, err := v.validateToken(context.Background(), token, v.config.leeway, nil)
+	return err
+}
+
+// ValidateTokenContext validates the token like ValidateToken,
+// retrieving its secret with ctx when the secret provider is
+// a ContextSecretProvider.
+func (v *JWTValidator) ValidateTokenContext(ctx context.Context, token *jwt.JSONWebToken) error {
+	_, err := v.validateToken(ctx, token, v.config.leeway, nil)
+	return err
 }
 
 func (v *JWTValidator) ValidateTokenWithLeeway(token *jwt.JSONWebToken, leeway time.Duration) error {
-	return v.validateTokenWithLeeway(token, leeway)
+	_, err := v.validateToken(context.Background(), token, leeway, nil)
+	return err
 }
 
-func (v *JWTValidator) validateTokenWithLeeway(token *jwt.JSONWebToken, leeway time.Duration) error {
+// validateTokenWithLeeway verifies the token, validates its registered
+// claims and returns them along with the full claim set.
+// The claims are also unmarshalled into dest, if any.
+// The secret of the token is retrieved with ctx.
+func (v *JWTValidator) validateTokenWithLeeway(ctx context.Context, token *jwt.JSONWebToken, leeway time.Duration, dest ...interface{}) (*ValidatedToken, error) {
 	if len(token.Headers) < 1 {
-		return ErrNoJWTHeaders
+		return nil, newValidationError(ReasonMalformed, ErrNoJWTHeaders)
 	}
 
+	header := token.Headers[0]
+	if header.Algorithm == "none" {
+		return nil, newValidationError(ReasonInvalidAlgorithm, ErrInvalidAlgorithm)
+	}
 	// trust secret provider when sig alg not configured and skip check
-	if v.config.signIn != "" {
-		header := token.Headers[0]
-		if header.Algorithm != string(v.config.signIn) {
-			return ErrInvalidAlgorithm
-		}
+	if len(v.config.signIn) > 0 && !v.config.allowsAlgorithm(header.Algorithm) {
+		return nil, newValidationError(ReasonInvalidAlgorithm, ErrInvalidAlgorithm)
 	}
 
-	claims := jwt.Claims{}
-	key, err := v.config.secretProvider.GetSecret(token)
+	key, err := getSecret(ctx, v.config.secretProvider, token)
 	if err != nil {
-		return err
+		return nil, newValidationError(keyReason(err), err)
+	}
+	// prevent alg confusion, such as an HMAC token checked against a public key
+	if !keyMatchesAlgorithm(key, header.Algorithm) {
+		return nil, newValidationError(ReasonInvalidAlgorithm, ErrInvalidKeyType)
 	}
 
-	if err = token.Claims(key, &claims); err != nil {
-		return err
+	validated := &ValidatedToken{
+		Token:        token,
+		CustomClaims: map[string]interface{}{},
+		KeyID:        header.KeyID,
+	}
+	if jwk, ok := key.(jose.JSONWebKey); ok {
+		validated.KeyID = jwk.KeyID
+	}
+	if err = token.Claims(key, append([]interface{}{&validated.Claims, &validated.CustomClaims}, dest...)...); err != nil {
+		return nil, newValidationError(claimsReason(err), err)
 	}
 
-	expected := v.config.expectedClaims.WithTime(time.Now())
-	err = claims.ValidateWithLeeway(expected, leeway)
-	return err
+	if v.config.requireExpiry && validated.Claims.Expiry == 0 {
+		return nil, newValidationError(ReasonInvalidClaims, ErrMissingExpiry)
+	}
+
+	now := v.config.clock.Now()
+	expected := v.config.expectedClaims.WithTime(now)
+	// the audience is matched below according to the policy of the configuration
+	expected.Audience = nil
+	if err = validated.Claims.ValidateWithLeeway(expected, leeway); err != nil {
+		return nil, newValidationError(claimsReason(err), err)
+	}
+	if err = v.config.audiencePolicy.validate(v.config.expectedClaims.Audience, validated.Claims.Audience); err != nil {
+		return nil, newValidationError(ReasonWrongAudience, err)
+	}
+	if err = v.config.validateTokenAge(validated.Claims, now, leeway); err != nil {
+		return nil, newValidationError(claimsReason(err), err)
+	}
+	for _, name := range v.config.requiredClaims {
+		if _, ok := validated.CustomClaims[name]; !ok {
+			return nil, newValidationError(ReasonInvalidClaims, fmt.Errorf("%w: %s", ErrMissingRequiredClaim, name))
+		}
+	}
+	for _, claimsValidator := range v.config.claimsValidators {
+		if err = claimsValidator.ValidateClaims(validated); err != nil {
+			return nil, newValidationError(ReasonInvalidClaims, err)
+		}
+	}
+	for _, revoker := range v.config.revokers {
+		revoked, err := revoker.IsRevoked(validated)
+		if err != nil {
+			return nil, newValidationError(ReasonRevoked, err)
+		}
+		if revoked {
+			return nil, newValidationError(ReasonRevoked, ErrTokenRevoked)
+		}
+	}
+	return validated, nil
+}
+
+// useTokenID records the ID of the validated token in the replay cache of
+// the configuration. It is the last step of every validation, so that only
+// the IDs of the tokens accepted are used.
+func (v *JWTValidator) useTokenID(validated *ValidatedToken, leeway time.Duration) error {
+	if v.config.replayCache == nil {
+		return nil
+	}
+	if validated.Claims.ID == "" {
+		return newValidationError(ReasonInvalidClaims, ErrMissingTokenID)
+	}
+	// the token remains valid for the leeway after its expiry
+	if err := v.config.replayCache.Use(validated.Claims.ID, validated.Claims.Expiry.Time().Add(leeway)); err != nil {
+		return newValidationError(ReasonReplayed, err)
+	}
+	return nil
 }
 
 // Claims unmarshall the claims of the provided token
@@ -148,4 +509,4 @@ func (v *JWTValidator) Claims(token *jwt.JSONWebToken, values ...interface{}) er
 		return err
 	}
 	return token.Claims(key, values...)
-}
\ No newline at end of file
+}
@@ -1,6 +1,8 @@
 package auth0
 
 import (
+	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/http"
@@ -8,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
@@ -133,7 +136,7 @@ func TestValidateRequestAndClaims(t *testing.T) {
 				jose.RS256,
 				defaultSecretRS256,
 			),
-			expectedErrorMsg: "error in cryptographic primitive",
+			expectedErrorMsg: "key type does not match the algorithm",
 		},
 		{
 			name: "fail - invalid config secret provider",
@@ -400,7 +403,7 @@ func TestValidateRequestAndClaimsWithLeeway(t *testing.T) {
 				defaultSecretRS256,
 			),
 			leeway:           jwt.DefaultLeeway,
-			expectedErrorMsg: "error in cryptographic primitive",
+			expectedErrorMsg: "key type does not match the algorithm",
 		},
 		{
 			name: "fail - invalid config secret provider",
@@ -539,3 +542,178 @@ func TestValidateRequestAndClaimsWithLeeway(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateAllowedAlgorithms(t *testing.T) {
+	secretES256 := genECDSAJWK(jose.ES256, "")
+	publicRS256 := defaultSecretRS256.Public()
+	publicES256 := secretES256.Public()
+	// Provider returning the key matching the kid, as a JWKS would.
+	provider := SecretProviderFunc(func(token *jwt.JSONWebToken) (interface{}, error) {
+		switch token.Headers[0].KeyID {
+		case "rsa":
+			return publicRS256.Key, nil
+		case "ecdsa":
+			return publicES256, nil
+		}
+		return nil, ErrNoKeyFound
+	})
+	rsaPEM, _ := x509.MarshalPKIXPublicKey(publicRS256.Key)
+
+	tests := []struct {
+		name          string
+		configuration Configuration
+		token         *jwt.JSONWebToken
+		expectedError error
+	}{
+		{
+			name:          "pass - RS256 allowed",
+			configuration: NewConfigurationWithAlgorithms(provider, defaultAudience, defaultIssuer, jose.RS256, jose.ES256),
+			token:         getTestTokenWithKid(defaultAudience, defaultIssuer, time.Now().Add(24*time.Hour), jose.RS256, defaultSecretRS256.Key, "rsa"),
+		},
+		{
+			name:          "pass - ES256 allowed",
+			configuration: NewConfigurationWithAlgorithms(provider, defaultAudience, defaultIssuer, jose.RS256, jose.ES256),
+			token:         getTestTokenWithKid(defaultAudience, defaultIssuer, time.Now().Add(24*time.Hour), jose.ES256, secretES256, "ecdsa"),
+		},
+		{
+			name:          "fail - HS256 not allowed",
+			configuration: NewConfigurationWithAlgorithms(provider, defaultAudience, defaultIssuer, jose.RS256, jose.ES256),
+			token:         getTestTokenWithKid(defaultAudience, defaultIssuer, time.Now().Add(24*time.Hour), jose.HS256, rsaPEM, "rsa"),
+			expectedError: ErrInvalidAlgorithm,
+		},
+		{
+			name:          "fail - HS256 signed with the public key",
+			configuration: NewConfigurationTrustProvider(provider, defaultAudience, defaultIssuer),
+			token:         getTestTokenWithKid(defaultAudience, defaultIssuer, time.Now().Add(24*time.Hour), jose.HS256, rsaPEM, "rsa"),
+			expectedError: ErrInvalidKeyType,
+		},
+		{
+			name:          "fail - ES384 token with an ES256 key",
+			configuration: NewConfigurationTrustProvider(provider, defaultAudience, defaultIssuer),
+			token:         getTestTokenWithKid(defaultAudience, defaultIssuer, time.Now().Add(24*time.Hour), jose.ES384, defaultSecretES384, "ecdsa"),
+			expectedError: ErrInvalidKeyType,
+		},
+		{
+			name:          "fail - RS256 token with an ECDSA key",
+			configuration: NewConfigurationWithAlgorithms(provider, defaultAudience, defaultIssuer, jose.RS256, jose.ES256),
+			token:         getTestTokenWithKid(defaultAudience, defaultIssuer, time.Now().Add(24*time.Hour), jose.RS256, defaultSecretRS256.Key, "ecdsa"),
+			expectedError: ErrInvalidKeyType,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := NewValidator(test.configuration, nil)
+			err := validator.ValidateToken(test.token)
+			if test.expectedError == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, test.expectedError), "expected %v, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestValidateTokenClaims(t *testing.T) {
+	type appMetadata struct {
+		Authorization struct {
+			Groups []string `json:"groups"`
+		} `json:"authorization"`
+	}
+	type customClaims struct {
+		Scope       string      `json:"scope"`
+		Roles       []string    `json:"roles"`
+		TaggedRoles []string    `json:"https://example.com/roles"`
+		AppMetadata appMetadata `json:"app_metadata"`
+	}
+
+	token := getTestTokenWithClaims(map[string]interface{}{
+		"iss":                       defaultIssuer,
+		"aud":                       defaultAudience,
+		"exp":                       jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		"scope":                     "read:news",
+		"roles":                     []string{"shadowed"},
+		"https://example.com/roles": []string{"admin"},
+		"app_metadata":              map[string]interface{}{"authorization": map[string]interface{}{"groups": []string{"Admin"}}},
+	}, jose.HS256, defaultSecret)
+
+	tests := []struct {
+		name          string
+		opts          []ConfigOption
+		expectedRoles []string
+	}{
+		{
+			name:          "pass - no namespace",
+			expectedRoles: []string{"shadowed"},
+		},
+		{
+			name:          "pass - namespace",
+			opts:          []ConfigOption{WithClaimsNamespace("https://example.com/")},
+			expectedRoles: []string{"admin"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			provider := SecretProviderFunc(func(token *jwt.JSONWebToken) (interface{}, error) {
+				calls++
+				return defaultSecret, nil
+			})
+			configuration := NewConfigurationWithOptions(provider, append([]ConfigOption{WithIssuer(defaultIssuer)}, test.opts...)...)
+			validator, req := genTestConfiguration(configuration, token)
+
+			custom := customClaims{}
+			validated, err := validator.ValidateRequestClaims(req, &custom)
+			if err != nil {
+				t.Fatalf("Validation should not have failed with error, but got: %v", err)
+			}
+
+			assert.Equal(t, 1, calls, "the secret should be retrieved once")
+			assert.Equal(t, defaultIssuer, validated.Claims.Issuer)
+			assert.Equal(t, "read:news", custom.Scope)
+			assert.Equal(t, test.expectedRoles, custom.Roles)
+			assert.Equal(t, []string{"admin"}, custom.TaggedRoles)
+			assert.Equal(t, []string{"Admin"}, custom.AppMetadata.Authorization.Groups)
+		})
+	}
+
+	validator, req := genTestConfiguration(NewConfiguration(defaultSecretProvider, defaultAudience, "invalid iss", jose.HS256), token)
+	_, err := validator.ValidateRequestClaims(req, &customClaims{})
+	assert.True(t, errors.Is(err, jwt.ErrInvalidIssuer))
+}
+
+func TestValidateWithContextSecretProvider(t *testing.T) {
+	type ctxKey struct{}
+	var received []context.Context
+	provider := ContextSecretProviderFunc(func(ctx context.Context, token *jwt.JSONWebToken) (interface{}, error) {
+		received = append(received, ctx)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return defaultSecret, nil
+	})
+	configuration := NewConfiguration(provider, defaultAudience, defaultIssuer, jose.HS256)
+	token := getTestToken(defaultAudience, defaultIssuer, time.Now().Add(time.Hour), jose.HS256, defaultSecret)
+	validator, req := genTestConfiguration(configuration, token)
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	_, err := validator.ValidateRequest(req.WithContext(ctx))
+	assert.NoError(t, err)
+	_, err = validator.ValidateRequestClaims(req.WithContext(ctx), &map[string]interface{}{})
+	assert.NoError(t, err)
+
+	parsed, err := jwt.ParseSigned(token)
+	assert.NoError(t, err)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = validator.ValidateTokenContext(canceled, parsed)
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonKeyFetchFailed}))
+
+	assert.NoError(t, validator.ValidateToken(parsed))
+	assert.Len(t, received, 4)
+	for _, c := range received[:3] {
+		assert.Equal(t, "request", c.Value(ctxKey{}))
+	}
+}
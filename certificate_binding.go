@@ -0,0 +1,36 @@
+package auth0
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrNoClientCertificate is returned when a certificate-bound
+	// token is sent over a connection without client certificate.
+	ErrNoClientCertificate = errors.New("certificate-bound token without client certificate")
+	// ErrCertificateMismatch is returned when the client certificate of the
+	// connection is not the one the token is bound to (cnf.x5t#S256).
+	ErrCertificateMismatch = errors.New("access token is not bound to the client certificate")
+)
+
+// verifyCertificateBinding checks that the client certificate of the
+// mutual TLS connection is the one the token is bound to (RFC 8705).
+// Tokens without cnf.x5t#S256 claim are not bound to any certificate.
+func verifyCertificateBinding(r *http.Request, token *ValidatedToken) error {
+	thumbprint := confirmationClaim(token.CustomClaims, "x5t#S256")
+	if thumbprint == "" {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ErrNoClientCertificate
+	}
+
+	hash := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	if thumbprint != base64.RawURLEncoding.EncodeToString(hash[:]) {
+		return ErrCertificateMismatch
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package auth0
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func genTestClientCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	hash := sha256.Sum256(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func TestCertificateBinding(t *testing.T) {
+	certificate, thumbprint := genTestClientCertificate(t)
+	_, otherThumbprint := genTestClientCertificate(t)
+	tokenBoundTo := func(thumbprint string) string {
+		claims := map[string]interface{}{
+			"iss": defaultIssuer,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if thumbprint != "" {
+			claims["cnf"] = map[string]string{"x5t#S256": thumbprint}
+		}
+		return getTestTokenWithClaims(claims, jose.HS256, defaultSecret)
+	}
+
+	configuration := NewConfigurationWithOptions(defaultSecretProvider, WithIssuer(defaultIssuer), WithCertificateBinding())
+	validator := NewValidator(configuration, nil)
+	ts := httptest.NewUnstartedServer(validator.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name                    string
+		token                   string
+		clientCertificate       bool
+		expectedStatus          int
+		expectedWWWAuthenticate string
+	}{
+		{
+			name:              "pass - bound to the client certificate",
+			token:             tokenBoundTo(thumbprint),
+			clientCertificate: true,
+			expectedStatus:    http.StatusOK,
+		},
+		{
+			name:              "pass - token not bound",
+			token:             tokenBoundTo(""),
+			clientCertificate: true,
+			expectedStatus:    http.StatusOK,
+		},
+		{
+			name:                    "fail - bound to another certificate",
+			token:                   tokenBoundTo(otherThumbprint),
+			clientCertificate:       true,
+			expectedStatus:          http.StatusUnauthorized,
+			expectedWWWAuthenticate: `Bearer error="invalid_token", error_description="certificate_mismatch"`,
+		},
+		{
+			name:                    "fail - no client certificate",
+			token:                   tokenBoundTo(thumbprint),
+			expectedStatus:          http.StatusUnauthorized,
+			expectedWWWAuthenticate: `Bearer error="invalid_token", error_description="certificate_mismatch"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport := ts.Client().Transport.(*http.Transport).Clone()
+			if test.clientCertificate {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+			}
+			client := &http.Client{Transport: transport}
+
+			req, err := http.NewRequest("GET", ts.URL, nil)
+			assert.Nil(t, err)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+			resp, err := client.Do(req)
+			assert.Nil(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.expectedStatus, resp.StatusCode)
+			assert.Equal(t, test.expectedWWWAuthenticate, resp.Header.Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestCertificateBindingWithoutTLS(t *testing.T) {
+	_, thumbprint := genTestClientCertificate(t)
+	token := getTestTokenWithClaims(map[string]interface{}{
+		"iss": defaultIssuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"cnf": map[string]string{"x5t#S256": thumbprint},
+	}, jose.HS256, defaultSecret)
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	_, err := NewValidator(NewConfigurationWithOptions(defaultSecretProvider), nil).ValidateRequest(req)
+	assert.Nil(t, err, "the binding should only be checked when enabled")
+
+	_, err = NewValidator(NewConfigurationWithOptions(defaultSecretProvider, WithCertificateBinding()), nil).ValidateRequest(req)
+	assert.True(t, errors.Is(err, ErrNoClientCertificate), "unexpected error: %v", err)
+}
@@ -0,0 +1,17 @@
+package auth0
+
+// ClaimsValidator validates the claims of a token
+// beyond the registered ones, once the token has
+// passed every other check.
+type ClaimsValidator interface {
+	ValidateClaims(token *ValidatedToken) error
+}
+
+// ClaimsValidatorFunc simple wrapper to validate
+// claims with functions.
+type ClaimsValidatorFunc func(token *ValidatedToken) error
+
+// ValidateClaims implements the ClaimsValidator interface.
+func (f ClaimsValidatorFunc) ValidateClaims(token *ValidatedToken) error {
+	return f(token)
+}
@@ -0,0 +1,95 @@
+package auth0
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+var (
+	errWrongAZP           = errors.New("azp does not match the SPA client")
+	errMissingOrg         = errors.New("org_id is missing")
+	errClientCredentials  = errors.New("client credentials tokens are not allowed")
+	userEndpointValidator = []ClaimsValidator{
+		ClaimsValidatorFunc(func(token *ValidatedToken) error {
+			if token.CustomClaims["azp"] != "spa-client" {
+				return errWrongAZP
+			}
+			return nil
+		}),
+		ClaimsValidatorFunc(func(token *ValidatedToken) error {
+			if _, ok := token.CustomClaims["org_id"]; !ok {
+				return errMissingOrg
+			}
+			return nil
+		}),
+		ClaimsValidatorFunc(func(token *ValidatedToken) error {
+			if token.CustomClaims["gty"] == "client-credentials" {
+				return errClientCredentials
+			}
+			return nil
+		}),
+	}
+)
+
+func TestClaimsValidators(t *testing.T) {
+	tests := []struct {
+		name          string
+		claims        map[string]interface{}
+		expectedError error
+	}{
+		{
+			name:   "pass - user token",
+			claims: map[string]interface{}{"azp": "spa-client", "org_id": "org_1"},
+		},
+		{
+			name:          "fail - other client",
+			claims:        map[string]interface{}{"azp": "other-client", "org_id": "org_1"},
+			expectedError: errWrongAZP,
+		},
+		{
+			name:          "fail - no organization",
+			claims:        map[string]interface{}{"azp": "spa-client"},
+			expectedError: errMissingOrg,
+		},
+		{
+			name:          "fail - client credentials",
+			claims:        map[string]interface{}{"azp": "spa-client", "org_id": "org_1", "gty": "client-credentials"},
+			expectedError: errClientCredentials,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.claims["iss"] = defaultIssuer
+			test.claims["exp"] = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			configuration := NewConfigurationWithOptions(defaultSecretProvider, WithIssuer(defaultIssuer), WithClaimsValidators(userEndpointValidator...))
+			validator, req := genTestConfiguration(configuration, getTestTokenWithClaims(test.claims, jose.HS256, defaultSecret))
+
+			_, err := validator.ValidateRequest(req)
+			if test.expectedError == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, test.expectedError), "expected %v, got %v", test.expectedError, err)
+			assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonInvalidClaims}))
+		})
+	}
+}
+
+func TestClaimsValidatorsRunAfterRegisteredClaims(t *testing.T) {
+	var called bool
+	configuration := NewConfigurationWithOptions(defaultSecretProvider, WithClaimsValidators(ClaimsValidatorFunc(func(token *ValidatedToken) error {
+		called = true
+		return nil
+	})))
+	validator, req := genTestConfiguration(configuration, getTestToken(defaultAudience, defaultIssuer, time.Now().Add(-time.Hour), jose.HS256, defaultSecret))
+
+	_, err := validator.ValidateRequest(req)
+	assert.True(t, errors.Is(err, jwt.ErrExpired))
+	assert.False(t, called)
+}
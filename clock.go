@@ -0,0 +1,22 @@
+package auth0
+
+import "time"
+
+// Clock provides the current time to the validation
+// and the key caches, so that they can be tested
+// without waiting or crafting tokens in the past.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc simple wrapper to provide
+// the current time with functions.
+type ClockFunc func() time.Time
+
+// Now implements the Clock interface.
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// systemClock is the Clock used by default.
+var systemClock Clock = ClockFunc(time.Now)
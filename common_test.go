@@ -33,6 +33,23 @@ var (
 	defaultSecretProviderES384 = NewKeyProvider(defaultSecretES384.Public())
 )
 
+// fakeClock is a Clock whose time only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
 func genRSASSAJWK(sigAlg jose.SignatureAlgorithm, kid string) jose.JSONWebKey {
 	var bits int
 	if sigAlg == jose.RS256 {
@@ -75,19 +92,21 @@ func genECDSAJWK(sigAlg jose.SignatureAlgorithm, kid string) jose.JSONWebKey {
 }
 
 func getTestToken(audience []string, issuer string, expTime time.Time, alg jose.SignatureAlgorithm, key interface{}) string {
-	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
-	if err != nil {
-		panic(err)
-	}
-
-	cl := jwt.Claims{
+	return getTestTokenWithClaims(jwt.Claims{
 		Issuer:   issuer,
 		Audience: audience,
 		IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
 		Expiry:   jwt.NewNumericDate(expTime),
+	}, alg, key)
+}
+
+func getTestTokenWithClaims(claims interface{}, alg jose.SignatureAlgorithm, key interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		panic(err)
 	}
 
-	raw, err := jwt.Signed(signer).Claims(cl).CompactSerialize()
+	raw, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
 	if err != nil {
 		panic(err)
 	}
@@ -95,6 +114,15 @@ func getTestToken(audience []string, issuer string, expTime time.Time, alg jose.
 }
 
 func getTestTokenWithKid(audience []string, issuer string, expTime time.Time, alg jose.SignatureAlgorithm, key interface{}, kid string) *jwt.JSONWebToken {
+	token, err := jwt.ParseSigned(getTestTokenWithKidString(audience, issuer, expTime, alg, key, kid))
+	if err != nil {
+		panic(err)
+	}
+
+	return token
+}
+
+func getTestTokenWithKidString(audience []string, issuer string, expTime time.Time, alg jose.SignatureAlgorithm, key interface{}, kid string) string {
 	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{ExtraHeaders: map[jose.HeaderKey]interface{}{"kid": kid}}).WithType("JWT"))
 	if err != nil {
 		panic(err)
@@ -112,12 +140,7 @@ func getTestTokenWithKid(audience []string, issuer string, expTime time.Time, al
 		panic(err)
 	}
 
-	token, err := jwt.ParseSigned(tokenStr)
-	if err != nil {
-		panic(err)
-	}
-
-	return token
+	return tokenStr
 }
 
 func genNewTestServer(genJWKS bool) (JWKClientOptions, *jwt.JSONWebToken, *jwt.JSONWebToken, error) {
@@ -0,0 +1,158 @@
+package auth0
+
+import (
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// ConfigOption configures a Configuration
+// created with NewConfigurationWithOptions.
+type ConfigOption func(*Configuration)
+
+// WithAudience sets the audiences the tokens must be issued for.
+func WithAudience(audience ...string) ConfigOption {
+	return func(c *Configuration) {
+		c.expectedClaims.Audience = audience
+	}
+}
+
+// WithAudiencePolicy sets how the audiences of the tokens are matched
+// against the ones set with WithAudience. Defaults to AudienceAllOf.
+func WithAudiencePolicy(policy AudiencePolicy) ConfigOption {
+	return func(c *Configuration) {
+		c.audiencePolicy = policy
+	}
+}
+
+// WithIssuer sets the issuer the tokens must be issued by.
+func WithIssuer(issuer string) ConfigOption {
+	return func(c *Configuration) {
+		c.expectedClaims.Issuer = issuer
+	}
+}
+
+// WithAlgorithms restricts the algorithms the tokens can be signed with.
+func WithAlgorithms(algorithms ...jose.SignatureAlgorithm) ConfigOption {
+	return func(c *Configuration) {
+		c.signIn = algorithms
+	}
+}
+
+// WithLeeway sets the leeway used to compare time values
+// when validating requests and tokens.
+func WithLeeway(leeway time.Duration) ConfigOption {
+	return func(c *Configuration) {
+		c.leeway = leeway
+	}
+}
+
+// WithRequiredClaims sets claims that must be present in the tokens.
+func WithRequiredClaims(names ...string) ConfigOption {
+	return func(c *Configuration) {
+		c.requiredClaims = append(c.requiredClaims, names...)
+	}
+}
+
+// WithClock sets the clock the time values of the tokens are compared to.
+func WithClock(clock Clock) ConfigOption {
+	return func(c *Configuration) {
+		c.clock = clock
+	}
+}
+
+// WithClaimsNamespace sets the namespace of the Auth0 custom claims, such as
+// "https://example.com/", so that JWTValidator.ValidateTokenClaims can
+// unmarshal them into struct fields tagged without it.
+func WithClaimsNamespace(namespace string) ConfigOption {
+	return func(c *Configuration) {
+		c.claimsNamespace = namespace
+	}
+}
+
+// WithClaimsValidators adds validators run on the full claim set
+// of the tokens. Their errors are returned as a ValidationError
+// with the ReasonInvalidClaims reason, unless they already are one.
+func WithClaimsValidators(validators ...ClaimsValidator) ConfigOption {
+	return func(c *Configuration) {
+		c.claimsValidators = append(c.claimsValidators, validators...)
+	}
+}
+
+// WithMaxTokenAge rejects the tokens issued longer ago than age,
+// as well as the tokens without iat claim.
+func WithMaxTokenAge(age time.Duration) ConfigOption {
+	return func(c *Configuration) {
+		c.maxTokenAge = age
+	}
+}
+
+// WithMaxLifetime rejects the tokens whose exp is more than lifetime
+// after their iat, as well as the tokens without iat claim.
+func WithMaxLifetime(lifetime time.Duration) ConfigOption {
+	return func(c *Configuration) {
+		c.maxLifetime = lifetime
+	}
+}
+
+// WithRequireExpiry rejects the tokens without exp claim with
+// ErrMissingExpiry rather than with jwt.ErrExpired.
+func WithRequireExpiry() ConfigOption {
+	return func(c *Configuration) {
+		c.requireExpiry = true
+	}
+}
+
+// WithRejectFutureIssuedAt rejects the tokens whose iat claim is later
+// than the current time plus the leeway with ErrIssuedInTheFuture.
+func WithRejectFutureIssuedAt() ConfigOption {
+	return func(c *Configuration) {
+		c.rejectFutureIssuedAt = true
+	}
+}
+
+// WithReplayCache makes the tokens single-use, recording their jti
+// claim in cache once they passed every other check. Tokens without
+// jti claim are rejected with ErrMissingTokenID.
+func WithReplayCache(cache ReplayCache) ConfigOption {
+	return func(c *Configuration) {
+		c.replayCache = cache
+	}
+}
+
+// WithRevokers adds revokers denying the tokens that passed every
+// other check. Revoked tokens are rejected with ErrTokenRevoked.
+func WithRevokers(revokers ...Revoker) ConfigOption {
+	return func(c *Configuration) {
+		c.revokers = append(c.revokers, revokers...)
+	}
+}
+
+// WithDecryptionKeyProvider sets the provider of the keys decrypting
+// nested tokens, found by the extractors of the package in the compact
+// JWE form. The signed tokens they wrap are then validated as usual.
+func WithDecryptionKeyProvider(provider DecryptionKeyProvider) ConfigOption {
+	return func(c *Configuration) {
+		c.decryptionKeyProvider = provider
+	}
+}
+
+// WithDPoP enables the validation of the DPoP proofs of the requests,
+// for the tokens sent with the DPoP authorization scheme. DPoP-bound
+// tokens, with a cnf.jkt claim, are rejected when sent as bearer tokens.
+// Without a ReplayCache in the options, the proofs are rejected with
+// ErrReplayCacheFull above DefaultDPoPProofRate proofs per second.
+func WithDPoP(options DPoPOptions) ConfigOption {
+	return func(c *Configuration) {
+		c.dpop = &options
+	}
+}
+
+// WithCertificateBinding rejects the certificate-bound tokens (RFC 8705)
+// whose cnf.x5t#S256 claim is not the thumbprint of the client
+// certificate of the mutual TLS connection of the request.
+func WithCertificateBinding() ConfigOption {
+	return func(c *Configuration) {
+		c.certificateBinding = true
+	}
+}
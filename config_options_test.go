@@ -0,0 +1,236 @@
+package auth0
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestNewConfigurationWithOptions(t *testing.T) {
+	configuration := NewConfigurationWithOptions(
+		defaultSecretProvider,
+		WithAudience(defaultAudience...),
+		WithIssuer(defaultIssuer),
+		WithAlgorithms(jose.HS256, jose.HS384),
+		WithLeeway(time.Second),
+		WithRequiredClaims("sub"),
+	)
+
+	assert.Equal(t, jwt.Audience(defaultAudience), configuration.expectedClaims.Audience)
+	assert.Equal(t, defaultIssuer, configuration.expectedClaims.Issuer)
+	assert.Equal(t, []jose.SignatureAlgorithm{jose.HS256, jose.HS384}, configuration.signIn)
+	assert.Equal(t, time.Second, configuration.leeway)
+	assert.Equal(t, []string{"sub"}, configuration.requiredClaims)
+
+	assert.Equal(t, jwt.DefaultLeeway, NewConfigurationWithOptions(defaultSecretProvider).leeway)
+
+	legacy := NewConfiguration(defaultSecretProvider, defaultAudience, defaultIssuer, jose.HS256)
+	assert.Equal(t, jwt.Expected{Issuer: defaultIssuer, Audience: defaultAudience}, legacy.expectedClaims)
+	assert.Equal(t, []jose.SignatureAlgorithm{jose.HS256}, legacy.signIn)
+	assert.Equal(t, jwt.DefaultLeeway, legacy.leeway)
+	assert.Empty(t, NewConfiguration(defaultSecretProvider, defaultAudience, defaultIssuer, "").signIn)
+}
+
+func TestValidateWithConfigOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []ConfigOption
+		expTime       time.Time
+		expectedError error
+	}{
+		{
+			name:    "pass - default options",
+			expTime: time.Now().Add(24 * time.Hour),
+		},
+		{
+			name:    "pass - expired within configured leeway",
+			opts:    []ConfigOption{WithLeeway(time.Hour)},
+			expTime: time.Now().Add(-30 * time.Minute),
+		},
+		{
+			name:          "fail - expired outside default leeway",
+			expTime:       time.Now().Add(-30 * time.Minute),
+			expectedError: jwt.ErrExpired,
+		},
+		{
+			name:    "pass - required claims present",
+			opts:    []ConfigOption{WithRequiredClaims("iss", "exp")},
+			expTime: time.Now().Add(24 * time.Hour),
+		},
+		{
+			name:          "fail - required claim missing",
+			opts:          []ConfigOption{WithRequiredClaims("iss", "sub")},
+			expTime:       time.Now().Add(24 * time.Hour),
+			expectedError: ErrMissingRequiredClaim,
+		},
+		{
+			name:          "fail - algorithm not allowed",
+			opts:          []ConfigOption{WithAlgorithms(jose.HS512)},
+			expTime:       time.Now().Add(24 * time.Hour),
+			expectedError: ErrInvalidAlgorithm,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := append([]ConfigOption{WithAudience(defaultAudience...), WithIssuer(defaultIssuer)}, test.opts...)
+			configuration := NewConfigurationWithOptions(defaultSecretProvider, opts...)
+			validator, req := genTestConfiguration(configuration, getTestToken(defaultAudience, defaultIssuer, test.expTime, jose.HS256, defaultSecret))
+
+			_, err := validator.ValidateRequest(req)
+			if test.expectedError == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, test.expectedError), "expected %v, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestValidateWithClock(t *testing.T) {
+	clock := newFakeClock()
+	issuedAt := clock.Now()
+
+	tests := []struct {
+		name          string
+		claims        jwt.Claims
+		elapsed       time.Duration
+		expectedError error
+	}{
+		{
+			name:    "pass - before expiry",
+			claims:  jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt), Expiry: jwt.NewNumericDate(issuedAt.Add(time.Hour))},
+			elapsed: 30 * time.Minute,
+		},
+		{
+			name:          "fail - after expiry",
+			claims:        jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt), Expiry: jwt.NewNumericDate(issuedAt.Add(time.Hour))},
+			elapsed:       2 * time.Hour,
+			expectedError: jwt.ErrExpired,
+		},
+		{
+			name:          "fail - before nbf",
+			claims:        jwt.Claims{NotBefore: jwt.NewNumericDate(issuedAt.Add(time.Hour)), Expiry: jwt.NewNumericDate(issuedAt.Add(2 * time.Hour))},
+			elapsed:       30 * time.Minute,
+			expectedError: jwt.ErrNotValidYet,
+		},
+		{
+			name:   "pass - iat in the future not checked",
+			claims: jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt.Add(time.Hour)), Expiry: jwt.NewNumericDate(issuedAt.Add(2 * time.Hour))},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock()
+			configuration := NewConfigurationWithOptions(defaultSecretProvider, WithClock(clock))
+			validator, req := genTestConfiguration(configuration, getTestTokenWithClaims(test.claims, jose.HS256, defaultSecret))
+			clock.Add(test.elapsed)
+
+			_, err := validator.ValidateRequest(req)
+			if test.expectedError == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, test.expectedError), "expected %v, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestValidateTokenAge(t *testing.T) {
+	clock := newFakeClock()
+	issuedAt := clock.Now()
+
+	tests := []struct {
+		name           string
+		opts           []ConfigOption
+		claims         jwt.Claims
+		elapsed        time.Duration
+		expectedError  error
+		expectedReason Reason
+	}{
+		{
+			name:    "pass - within max age",
+			opts:    []ConfigOption{WithMaxTokenAge(2 * time.Hour)},
+			claims:  jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt), Expiry: jwt.NewNumericDate(issuedAt.Add(24 * time.Hour))},
+			elapsed: time.Hour,
+		},
+		{
+			name:           "fail - older than max age",
+			opts:           []ConfigOption{WithMaxTokenAge(2 * time.Hour)},
+			claims:         jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt), Expiry: jwt.NewNumericDate(issuedAt.Add(24 * time.Hour))},
+			elapsed:        3 * time.Hour,
+			expectedError:  ErrTokenTooOld,
+			expectedReason: ReasonExpired,
+		},
+		{
+			name:           "fail - max age without iat",
+			opts:           []ConfigOption{WithMaxTokenAge(2 * time.Hour)},
+			claims:         jwt.Claims{Expiry: jwt.NewNumericDate(issuedAt.Add(24 * time.Hour))},
+			expectedError:  ErrMissingIssuedAt,
+			expectedReason: ReasonInvalidClaims,
+		},
+		{
+			name:   "pass - within max lifetime",
+			opts:   []ConfigOption{WithMaxLifetime(24 * time.Hour)},
+			claims: jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt), Expiry: jwt.NewNumericDate(issuedAt.Add(24 * time.Hour))},
+		},
+		{
+			name:           "fail - longer than max lifetime",
+			opts:           []ConfigOption{WithMaxLifetime(24 * time.Hour)},
+			claims:         jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt), Expiry: jwt.NewNumericDate(issuedAt.Add(30 * 24 * time.Hour))},
+			expectedError:  ErrLifetimeTooLong,
+			expectedReason: ReasonInvalidClaims,
+		},
+		{
+			name:           "fail - expiry required",
+			opts:           []ConfigOption{WithRequireExpiry()},
+			claims:         jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt)},
+			expectedError:  ErrMissingExpiry,
+			expectedReason: ReasonInvalidClaims,
+		},
+		{
+			name:           "fail - iat in the future",
+			opts:           []ConfigOption{WithRejectFutureIssuedAt()},
+			claims:         jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt.Add(time.Hour)), Expiry: jwt.NewNumericDate(issuedAt.Add(2 * time.Hour))},
+			expectedError:  ErrIssuedInTheFuture,
+			expectedReason: ReasonNotYetValid,
+		},
+		{
+			name:   "pass - iat in the future within leeway",
+			opts:   []ConfigOption{WithRejectFutureIssuedAt()},
+			claims: jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt.Add(30 * time.Second)), Expiry: jwt.NewNumericDate(issuedAt.Add(time.Hour))},
+		},
+		{
+			name:   "pass - iat in the future without rejecting it",
+			claims: jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt.Add(time.Hour)), Expiry: jwt.NewNumericDate(issuedAt.Add(2 * time.Hour))},
+		},
+		{
+			name:           "fail - no expiry without requiring it",
+			claims:         jwt.Claims{IssuedAt: jwt.NewNumericDate(issuedAt)},
+			expectedError:  jwt.ErrExpired,
+			expectedReason: ReasonExpired,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock()
+			configuration := NewConfigurationWithOptions(defaultSecretProvider, append([]ConfigOption{WithClock(clock)}, test.opts...)...)
+			validator, req := genTestConfiguration(configuration, getTestTokenWithClaims(test.claims, jose.HS256, defaultSecret))
+			clock.Add(test.elapsed)
+
+			_, err := validator.ValidateRequest(req)
+			if test.expectedError == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, test.expectedError), "expected %v, got %v", test.expectedError, err)
+				assert.True(t, errors.Is(err, &ValidationError{Reason: test.expectedReason}), "expected reason %v, got %v", test.expectedReason, err)
+			}
+		})
+	}
+}
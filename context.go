@@ -0,0 +1,57 @@
+package auth0
+
+import (
+	"context"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type contextKey int
+
+const (
+	validatedTokenContextKey contextKey = iota
+	decryptionKeyProviderContextKey
+)
+
+// ValidatedToken bundles a token that passed
+// validation with its decoded claims.
+type ValidatedToken struct {
+	// Token is the verified token.
+	Token *jwt.JSONWebToken
+	// Claims holds the registered claims of the token.
+	Claims jwt.Claims
+	// CustomClaims holds the full claim set of the token,
+	// including claims such as scope or permissions.
+	CustomClaims map[string]interface{}
+	// KeyID is the ID of the key that verified the token signature.
+	KeyID string
+}
+
+// NewContext returns a copy of ctx carrying the validated token.
+func NewContext(ctx context.Context, token *ValidatedToken) context.Context {
+	return context.WithValue(ctx, validatedTokenContextKey, token)
+}
+
+// FromContext returns the validated token stored in ctx, if any.
+func FromContext(ctx context.Context) (*ValidatedToken, bool) {
+	token, ok := ctx.Value(validatedTokenContextKey).(*ValidatedToken)
+	return token, ok && token != nil
+}
+
+// TokenFromContext returns the token stored in ctx, if any.
+func TokenFromContext(ctx context.Context) (*jwt.JSONWebToken, bool) {
+	validated, ok := FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return validated.Token, true
+}
+
+// ClaimsFromContext returns the full claim set of the token stored in ctx, if any.
+func ClaimsFromContext(ctx context.Context) (map[string]interface{}, bool) {
+	validated, ok := FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return validated.CustomClaims, true
+}
@@ -0,0 +1,39 @@
+package auth0
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestContext(t *testing.T) {
+	configuration := NewConfiguration(defaultSecretProvider, defaultAudience, defaultIssuer, jose.HS256)
+	validator, req := genTestConfiguration(configuration, getTestToken(defaultAudience, defaultIssuer, time.Now().Add(24*time.Hour), jose.HS256, defaultSecret))
+
+	validated, err := validator.validateRequest(req, time.Minute)
+	if err != nil {
+		t.Fatalf("Validation should not have failed with error, but got: %v", err)
+	}
+
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+	_, ok = FromContext(NewContext(context.Background(), nil))
+	assert.False(t, ok)
+
+	ctx := NewContext(context.Background(), validated)
+	fromCtx, ok := FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, defaultIssuer, fromCtx.Claims.Issuer)
+	assert.Equal(t, defaultIssuer, fromCtx.CustomClaims["iss"])
+
+	token, ok := TokenFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, validated.Token, token)
+
+	claims, ok := ClaimsFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, validated.CustomClaims, claims)
+}
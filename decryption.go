@@ -0,0 +1,75 @@
+package auth0
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+var (
+	// ErrNoDecryptionKeyProvider is returned when an encrypted token is
+	// extracted without any DecryptionKeyProvider in the configuration.
+	ErrNoDecryptionKeyProvider = errors.New("encrypted token but no decryption key provider")
+	// ErrDecryptionFailed is returned when an encrypted token cannot be decrypted.
+	ErrDecryptionFailed = errors.New("token could not be decrypted")
+)
+
+// DecryptionKeyProvider provides the key
+// decrypting nested tokens (JWE wrapping a JWS).
+type DecryptionKeyProvider interface {
+	GetDecryptionKey(token *jwt.NestedJSONWebToken) (interface{}, error)
+}
+
+// DecryptionKeyProviderFunc simple wrapper to provide
+// decryption keys with functions.
+type DecryptionKeyProviderFunc func(token *jwt.NestedJSONWebToken) (interface{}, error)
+
+// GetDecryptionKey implements the DecryptionKeyProvider interface.
+func (f DecryptionKeyProviderFunc) GetDecryptionKey(token *jwt.NestedJSONWebToken) (interface{}, error) {
+	return f(token)
+}
+
+// NewDecryptionKeyProvider provide a simple decryption key provider.
+func NewDecryptionKeyProvider(key interface{}) DecryptionKeyProvider {
+	return DecryptionKeyProviderFunc(func(_ *jwt.NestedJSONWebToken) (interface{}, error) {
+		return key, nil
+	})
+}
+
+// withDecryptionKeyProvider returns a copy of ctx carrying
+// the provider used by the extractors to decrypt tokens.
+func withDecryptionKeyProvider(ctx context.Context, provider DecryptionKeyProvider) context.Context {
+	return context.WithValue(ctx, decryptionKeyProviderContextKey, provider)
+}
+
+// parseToken parses the raw token extracted from r. Nested tokens, in the
+// five parts compact JWE form, are decrypted with the DecryptionKeyProvider
+// set by the validator on the request context, and the signed token they
+// wrap is returned.
+func parseToken(r *http.Request, raw string) (*jwt.JSONWebToken, error) {
+	if strings.Count(raw, ".") != 4 {
+		return jwt.ParseSigned(raw)
+	}
+
+	nested, err := jwt.ParseSignedAndEncrypted(raw)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := r.Context().Value(decryptionKeyProviderContextKey).(DecryptionKeyProvider)
+	if !ok {
+		return nil, ErrNoDecryptionKeyProvider
+	}
+	key, err := provider.GetDecryptionKey(nested)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	token, err := nested.Decrypt(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	return token, nil
+}
@@ -0,0 +1,105 @@
+package auth0
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func getTestEncryptedToken(claims interface{}, alg jose.SignatureAlgorithm, key interface{}, encryptionKey *rsa.PublicKey) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		panic(err)
+	}
+	encrypter, err := jose.NewEncrypter(jose.A128GCM, jose.Recipient{Algorithm: jose.RSA_OAEP, Key: encryptionKey}, (&jose.EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		panic(err)
+	}
+
+	raw, err := jwt.SignedAndEncrypted(signer, encrypter).Claims(claims).CompactSerialize()
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func TestValidateEncryptedToken(t *testing.T) {
+	encryptionKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	claims := jwt.Claims{Issuer: defaultIssuer, Audience: defaultAudience, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	encryptedToken := getTestEncryptedToken(claims, jose.HS256, defaultSecret, &encryptionKey.PublicKey)
+
+	tests := []struct {
+		name          string
+		provider      DecryptionKeyProvider
+		extractor     RequestTokenExtractor
+		token         string
+		expectedError error
+	}{
+		{
+			name:     "pass - encrypted token",
+			provider: NewDecryptionKeyProvider(encryptionKey),
+			token:    encryptedToken,
+		},
+		{
+			name:     "pass - signed token with a decryption key provider",
+			provider: NewDecryptionKeyProvider(encryptionKey),
+			token:    getTestTokenWithClaims(claims, jose.HS256, defaultSecret),
+		},
+		{
+			name:      "pass - encrypted token in a cookie",
+			provider:  NewDecryptionKeyProvider(encryptionKey),
+			extractor: FromMultiple(RequestTokenExtractorFunc(FromHeader), RequestTokenExtractorFunc(FromCookie)),
+			token:     encryptedToken,
+		},
+		{
+			name:          "fail - no decryption key provider",
+			token:         encryptedToken,
+			expectedError: ErrNoDecryptionKeyProvider,
+		},
+		{
+			name:          "fail - wrong decryption key",
+			provider:      NewDecryptionKeyProvider(otherKey),
+			token:         encryptedToken,
+			expectedError: ErrDecryptionFailed,
+		},
+		{
+			name:          "fail - inner token with a bad signature",
+			provider:      NewDecryptionKeyProvider(encryptionKey),
+			token:         getTestEncryptedToken(claims, jose.HS256, []byte("other secret"), &encryptionKey.PublicKey),
+			expectedError: jose.ErrCryptoFailure,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := []ConfigOption{WithAudience(defaultAudience...), WithIssuer(defaultIssuer), WithAlgorithms(jose.HS256)}
+			if test.provider != nil {
+				opts = append(opts, WithDecryptionKeyProvider(test.provider))
+			}
+			validator := NewValidator(NewConfigurationWithOptions(defaultSecretProvider, opts...), test.extractor)
+
+			req := httptest.NewRequest("GET", "http://localhost", nil)
+			if test.extractor != nil {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: test.token})
+			} else {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			_, err := validator.ValidateRequest(req)
+			if test.expectedError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, test.expectedError), "expected %v, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package auth0
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+var (
+	// ErrIssuerMismatch is returned when the discovery document
+	// advertises another issuer than the requested one.
+	ErrIssuerMismatch = errors.New("issuer of the discovery document does not match")
+	// ErrNoJWKSURI is returned when the discovery document has no jwks_uri.
+	ErrNoJWKSURI = errors.New("no jwks_uri in the discovery document")
+	// ErrDiscoveryDocumentTooLarge is returned when the discovery document
+	// is larger than the MaxResponseSize of the JWKClientOptions.
+	ErrDiscoveryDocumentTooLarge = errors.New("discovery document is too large")
+)
+
+type discoveryDocument struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// NewConfigurationFromDiscovery creates a configuration for server from the
+// OpenID Connect discovery document of the issuer.
+// The issuer and the JWKS URI are taken from the document, and the signing
+// algorithms are restricted to the ones it advertises.
+func NewConfigurationFromDiscovery(ctx context.Context, issuerURL string, audience []string) (Configuration, error) {
+	configuration, _, err := NewConfigurationFromDiscoveryWithOptions(ctx, issuerURL, audience, JWKClientOptions{})
+	return configuration, err
+}
+
+// NewConfigurationFromDiscoveryWithOptions creates a configuration for server
+// like NewConfigurationFromDiscovery, downloading the discovery document with
+// the Client, the timeouts and the MaxResponseSize of options. The JWKClient
+// of the configuration is created with options and the JWKS URI of the
+// document, and returned so that it can be closed.
+func NewConfigurationFromDiscoveryWithOptions(ctx context.Context, issuerURL string, audience []string, options JWKClientOptions) (Configuration, *JWKClient, error) {
+	options = options.withDefaults()
+	doc, err := fetchDiscoveryDocument(ctx, options.Client, issuerURL, options.MaxResponseSize)
+	if err != nil {
+		return Configuration{}, nil, err
+	}
+
+	options.URI = doc.JWKSURI
+	client := NewJWKClient(options, nil)
+	configuration := NewConfigurationTrustProvider(client, audience, doc.Issuer)
+	for _, alg := range doc.IDTokenSigningAlgValuesSupported {
+		if alg != "none" {
+			configuration.signIn = append(configuration.signIn, jose.SignatureAlgorithm(alg))
+		}
+	}
+	return configuration, client, nil
+}
+
+func fetchDiscoveryDocument(ctx context.Context, client *http.Client, issuerURL string, maxSize int64) (*discoveryDocument, error) {
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", issuerURL+discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code fetching discovery document: %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, ErrDiscoveryDocumentTooLarge
+	}
+
+	doc := &discoveryDocument{}
+	if err = json.Unmarshal(body, doc); err != nil {
+		return nil, err
+	}
+
+	// Auth0 issuers end with a slash, compare without it so that both
+	// forms can be passed, but keep the exact value found in the tokens.
+	if strings.TrimSuffix(doc.Issuer, "/") != issuerURL {
+		return nil, ErrIssuerMismatch
+	}
+	if doc.JWKSURI == "" {
+		return nil, ErrNoJWKSURI
+	}
+	return doc, nil
+}
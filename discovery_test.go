@@ -0,0 +1,149 @@
+package auth0
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func genDiscoveryTestServer(issuerSuffix string, algs []string, keys ...jose.JSONWebKey) *httptest.Server {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	jsonWebKeyES384 := genECDSAJWK(jose.ES384, "keyES384")
+	if len(keys) == 2 {
+		jsonWebKeyRS256, jsonWebKeyES384 = keys[0], keys[1]
+	}
+	jwks, _ := json.Marshal(JWKS{Keys: []jose.JSONWebKey{jsonWebKeyRS256.Public(), jsonWebKeyES384.Public()}})
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case discoveryPath:
+			json.NewEncoder(w).Encode(discoveryDocument{
+				Issuer:                           ts.URL + issuerSuffix,
+				JWKSURI:                          ts.URL + "/.well-known/jwks.json",
+				IDTokenSigningAlgValuesSupported: algs,
+			})
+		case "/.well-known/jwks.json":
+			fmt.Fprintln(w, string(jwks))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return ts
+}
+
+func TestNewConfigurationFromDiscovery(t *testing.T) {
+	ts := genDiscoveryTestServer("/", []string{"RS256"})
+	defer ts.Close()
+
+	tests := []struct {
+		name             string
+		issuerURL        string
+		expectedErrorMsg string
+	}{
+		{
+			name:      "pass - issuer with trailing slash",
+			issuerURL: ts.URL + "/",
+		},
+		{
+			name:      "pass - issuer without trailing slash",
+			issuerURL: ts.URL,
+		},
+		{
+			name:             "fail - no discovery document",
+			issuerURL:        ts.URL + "/tenant",
+			expectedErrorMsg: "unexpected status code",
+		},
+		{
+			name:             "fail - invalid URL",
+			issuerURL:        "\t.://",
+			expectedErrorMsg: "invalid control character",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configuration, err := NewConfigurationFromDiscovery(context.Background(), test.issuerURL, defaultAudience)
+			if test.expectedErrorMsg != "" {
+				if err == nil {
+					t.Errorf("Discovery should have failed with error with substring: " + test.expectedErrorMsg)
+				} else if !strings.Contains(err.Error(), test.expectedErrorMsg) {
+					t.Errorf("Discovery should have failed with error with substring: " + test.expectedErrorMsg + ", but got: " + err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Discovery should not have failed with error, but got: " + err.Error())
+				return
+			}
+			assert.Equal(t, ts.URL+"/", configuration.expectedClaims.Issuer)
+			assert.Equal(t, []jose.SignatureAlgorithm{jose.RS256}, configuration.signIn)
+		})
+	}
+}
+
+func TestNewConfigurationFromDiscoveryIssuerMismatch(t *testing.T) {
+	ts := genDiscoveryTestServer("/other", []string{"RS256"})
+	defer ts.Close()
+
+	_, err := NewConfigurationFromDiscovery(context.Background(), ts.URL, defaultAudience)
+	assert.Equal(t, ErrIssuerMismatch, err)
+}
+
+func TestNewConfigurationFromDiscoveryValidation(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	jsonWebKeyES384 := genECDSAJWK(jose.ES384, "keyES384")
+	ts := genDiscoveryTestServer("/", []string{"RS256", "none"}, jsonWebKeyRS256, jsonWebKeyES384)
+	defer ts.Close()
+
+	configuration, err := NewConfigurationFromDiscovery(context.Background(), ts.URL, defaultAudience)
+	if err != nil {
+		t.Fatalf("Discovery should not have failed with error, but got: %v", err)
+	}
+	assert.Equal(t, []jose.SignatureAlgorithm{jose.RS256}, configuration.signIn)
+
+	validator := NewValidator(configuration, nil)
+
+	tokenRS256 := getTestTokenWithKid(defaultAudience, ts.URL+"/", time.Now().Add(24*time.Hour), jose.RS256, jsonWebKeyRS256, "keyRS256")
+	assert.NoError(t, validator.ValidateToken(tokenRS256))
+
+	tokenES384 := getTestTokenWithKid(defaultAudience, ts.URL+"/", time.Now().Add(24*time.Hour), jose.ES384, jsonWebKeyES384, "keyES384")
+	assert.True(t, errors.Is(validator.ValidateToken(tokenES384), ErrInvalidAlgorithm))
+}
+
+func TestNewConfigurationFromDiscoveryWithOptions(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	jsonWebKeyES384 := genECDSAJWK(jose.ES384, "keyES384")
+	ts := genDiscoveryTestServer("/", []string{"RS256"}, jsonWebKeyRS256, jsonWebKeyES384)
+	defer ts.Close()
+
+	var counter uint64
+	options := JWKClientOptions{
+		Client: &http.Client{Transport: &mockRoundTripper{ops: &counter, rt: http.DefaultTransport}},
+	}
+	configuration, client, err := NewConfigurationFromDiscoveryWithOptions(context.Background(), ts.URL, defaultAudience, options)
+	if err != nil {
+		t.Fatalf("Discovery should not have failed with error, but got: %v", err)
+	}
+	defer client.Close()
+
+	validator := NewValidator(configuration, nil)
+	tokenRS256 := getTestTokenWithKid(defaultAudience, ts.URL+"/", time.Now().Add(24*time.Hour), jose.RS256, jsonWebKeyRS256, "keyRS256")
+	assert.NoError(t, validator.ValidateToken(tokenRS256))
+	// the discovery document and the JWKS are both downloaded with the client
+	assert.Equal(t, uint64(2), counter)
+
+	options.MaxResponseSize = 16
+	_, _, err = NewConfigurationFromDiscoveryWithOptions(context.Background(), ts.URL, defaultAudience, options)
+	assert.Equal(t, ErrDiscoveryDocumentTooLarge, err)
+}
@@ -0,0 +1,250 @@
+package auth0
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// DefaultDPoPProofMaxAge is the default maximum age of the DPoP proofs.
+	DefaultDPoPProofMaxAge = time.Minute
+	// DefaultDPoPProofRate is the number of DPoP proofs per second the
+	// default replay cache is sized for, holding the IDs of the proofs
+	// issued within MaxAge plus the leeway.
+	DefaultDPoPProofRate = 1000
+
+	dpopProofType = "dpop+jwt"
+)
+
+var (
+	// ErrMissingDPoPProof is returned when a request carries no DPoP proof,
+	// or several of them, while one is required.
+	ErrMissingDPoPProof = errors.New("missing DPoP proof")
+	// ErrInvalidDPoPProof is returned when the DPoP proof of a request is invalid.
+	ErrInvalidDPoPProof = errors.New("invalid DPoP proof")
+	// ErrDPoPBindingMismatch is returned when the access token is not bound
+	// to the key of the DPoP proof, or is DPoP-bound but used as a bearer token.
+	ErrDPoPBindingMismatch = errors.New("access token is not bound to the DPoP proof key")
+	// ErrDPoPNotEnabled is returned when a request uses the DPoP scheme,
+	// or a DPoP-bound token, while DPoP is not enabled.
+	ErrDPoPNotEnabled = errors.New("DPoP is not enabled")
+)
+
+// DPoPOptions configures the validation of DPoP proofs (RFC 9449),
+// enabled with WithDPoP.
+type DPoPOptions struct {
+	// Required rejects the tokens not bound to a DPoP key.
+	// Otherwise, bearer tokens without cnf.jkt claim are accepted.
+	Required bool
+	// Algorithms restricts the algorithms the proofs can be signed with.
+	// Any asymmetric algorithm is accepted by default.
+	Algorithms []jose.SignatureAlgorithm
+	// MaxAge is the maximum age of the proofs, DefaultDPoPProofMaxAge by default.
+	MaxAge time.Duration
+	// ReplayCache records the IDs of the proofs, so that each one can only
+	// be used once. Defaults to a memory replay cache sized for
+	// DefaultDPoPProofRate proofs per second, above which the proofs are
+	// rejected with ErrReplayCacheFull: higher rates need a larger cache.
+	ReplayCache ReplayCache
+	// RequestURL returns the URL the proofs must be issued for (htu).
+	// Defaults to the scheme, the host and the path of the request,
+	// which may have to be overridden behind a reverse proxy.
+	RequestURL func(r *http.Request) string
+}
+
+type dpopProofClaims struct {
+	ID              string          `json:"jti"`
+	Method          string          `json:"htm"`
+	URI             string          `json:"htu"`
+	IssuedAt        jwt.NumericDate `json:"iat"`
+	AccessTokenHash string          `json:"ath"`
+}
+
+// verify checks that the request carries a valid DPoP proof
+// of the possession of the key its access token is bound to.
+func (o *DPoPOptions) verify(r *http.Request, token *ValidatedToken, now time.Time, leeway time.Duration) error {
+	jkt := confirmationClaim(token.CustomClaims, "jkt")
+	accessToken, ok := dpopAccessToken(r)
+	if !ok {
+		if jkt != "" {
+			return ErrDPoPBindingMismatch
+		}
+		if o.Required {
+			return ErrMissingDPoPProof
+		}
+		return nil
+	}
+
+	proofs := r.Header[http.CanonicalHeaderKey("DPoP")]
+	if len(proofs) != 1 {
+		return ErrMissingDPoPProof
+	}
+	proof, err := jwt.ParseSigned(proofs[0])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+	key, err := o.proofKey(proof)
+	if err != nil {
+		return err
+	}
+	claims := dpopProofClaims{}
+	if err = proof.Claims(key, &claims); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+
+	if claims.ID == "" {
+		return fmt.Errorf("%w: missing jti", ErrInvalidDPoPProof)
+	}
+	if claims.Method != r.Method {
+		return fmt.Errorf("%w: htm does not match the request method", ErrInvalidDPoPProof)
+	}
+	if !o.matchesRequestURL(r, claims.URI) {
+		return fmt.Errorf("%w: htu does not match the request URL", ErrInvalidDPoPProof)
+	}
+	maxAge := o.maxAge()
+	issuedAt := claims.IssuedAt.Time()
+	if claims.IssuedAt == 0 || issuedAt.After(now.Add(leeway)) || issuedAt.Before(now.Add(-maxAge-leeway)) {
+		return fmt.Errorf("%w: iat out of range", ErrInvalidDPoPProof)
+	}
+	hash := sha256.Sum256([]byte(accessToken))
+	if claims.AccessTokenHash != base64.RawURLEncoding.EncodeToString(hash[:]) {
+		return fmt.Errorf("%w: ath does not match the access token", ErrInvalidDPoPProof)
+	}
+
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+	if jkt != base64.RawURLEncoding.EncodeToString(thumbprint) {
+		return ErrDPoPBindingMismatch
+	}
+
+	if err = o.ReplayCache.Use(claims.ID, issuedAt.Add(maxAge+leeway)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+	return nil
+}
+
+// proofKey returns the public key of the proof header,
+// checking the type and the algorithm of the proof.
+func (o *DPoPOptions) proofKey(proof *jwt.JSONWebToken) (*jose.JSONWebKey, error) {
+	if len(proof.Headers) < 1 {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDPoPProof, ErrNoJWTHeaders)
+	}
+	header := proof.Headers[0]
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != dpopProofType {
+		return nil, fmt.Errorf("%w: typ is not %s", ErrInvalidDPoPProof, dpopProofType)
+	}
+	if header.Algorithm == "none" || strings.HasPrefix(header.Algorithm, "HS") || !o.allowsAlgorithm(header.Algorithm) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDPoPProof, ErrInvalidAlgorithm)
+	}
+	if header.JSONWebKey == nil || !header.JSONWebKey.IsPublic() || !keyMatchesAlgorithm(header.JSONWebKey, header.Algorithm) {
+		return nil, fmt.Errorf("%w: jwk is not a public key matching the algorithm", ErrInvalidDPoPProof)
+	}
+	return header.JSONWebKey, nil
+}
+
+func (o *DPoPOptions) maxAge() time.Duration {
+	if o.MaxAge <= 0 {
+		return DefaultDPoPProofMaxAge
+	}
+	return o.MaxAge
+}
+
+// defaultReplayCacheSize is the number of proof IDs held at
+// DefaultDPoPProofRate, each one for MaxAge plus the leeway.
+func (o *DPoPOptions) defaultReplayCacheSize(leeway time.Duration) int {
+	return int(DefaultDPoPProofRate * (o.maxAge() + leeway) / time.Second)
+}
+
+func (o *DPoPOptions) allowsAlgorithm(alg string) bool {
+	if len(o.Algorithms) == 0 {
+		return true
+	}
+	for _, allowed := range o.Algorithms {
+		if string(allowed) == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRequestURL compares the htu claim with the URL of the request,
+// ignoring its query and fragment as well as the default ports.
+func (o *DPoPOptions) matchesRequestURL(r *http.Request, htu string) bool {
+	requestURL := ""
+	if o.RequestURL != nil {
+		requestURL = o.RequestURL(r)
+	} else {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		requestURL = scheme + "://" + r.Host + r.URL.Path
+	}
+
+	expected, err := url.Parse(requestURL)
+	if err != nil {
+		return false
+	}
+	actual, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	return normalizeURL(expected) == normalizeURL(actual)
+}
+
+func normalizeURL(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	switch scheme {
+	case "http":
+		host = strings.TrimSuffix(host, ":80")
+	case "https":
+		host = strings.TrimSuffix(host, ":443")
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
+
+// rejectDPoP rejects the requests using the DPoP scheme and the DPoP-bound
+// tokens when DPoP is not enabled, since no proof would be checked.
+func rejectDPoP(r *http.Request, token *ValidatedToken) error {
+	if _, ok := dpopAccessToken(r); ok {
+		return newValidationError(ReasonMalformed, ErrDPoPNotEnabled)
+	}
+	if confirmationClaim(token.CustomClaims, "jkt") != "" {
+		return newValidationError(ReasonInvalidClaims, ErrDPoPNotEnabled)
+	}
+	return nil
+}
+
+// dpopAccessToken returns the access token of
+// an Authorization header with the DPoP scheme.
+func dpopAccessToken(r *http.Request) (string, bool) {
+	if h := r.Header.Get("Authorization"); len(h) > 5 && strings.EqualFold(h[0:5], "DPOP ") {
+		return h[5:], true
+	}
+	return "", false
+}
+
+// confirmationClaim returns the member of the cnf claim (RFC 7800)
+// binding the token to a key, such as jkt or x5t#S256.
+func confirmationClaim(claims map[string]interface{}, name string) string {
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	value, _ := cnf[name].(string)
+	return value
+}
@@ -0,0 +1,310 @@
+package auth0
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func getTestDPoPProof(key jose.JSONWebKey, typ string, claims interface{}) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.SignatureAlgorithm(key.Algorithm), Key: key.Key},
+		(&jose.SignerOptions{EmbedJWK: true}).WithType(jose.ContentType(typ)),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	raw, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func getTestThumbprint(key jose.JSONWebKey) string {
+	public := key.Public()
+	thumbprint, err := public.Thumbprint(crypto.SHA256)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+func TestDPoP(t *testing.T) {
+	clock := newFakeClock()
+	proofKey := genECDSAJWK(jose.ES256, "")
+	otherKey := genECDSAJWK(jose.ES256, "")
+
+	accessToken := func(jkt string) string {
+		claims := map[string]interface{}{
+			"iss": defaultIssuer,
+			"exp": clock.Now().Add(time.Hour).Unix(),
+		}
+		if jkt != "" {
+			claims["cnf"] = map[string]string{"jkt": jkt}
+		}
+		return getTestTokenWithClaims(claims, jose.HS256, defaultSecret)
+	}
+	boundToken := accessToken(getTestThumbprint(proofKey))
+	unboundToken := accessToken("")
+	proofClaims := func(token string) map[string]interface{} {
+		hash := sha256.Sum256([]byte(token))
+		return map[string]interface{}{
+			"jti": "proof-1",
+			"htm": "POST",
+			"htu": "https://api.example.com/payments",
+			"iat": clock.Now().Unix(),
+			"ath": base64.RawURLEncoding.EncodeToString(hash[:]),
+		}
+	}
+	with := func(claims map[string]interface{}, name string, value interface{}) map[string]interface{} {
+		claims[name] = value
+		return claims
+	}
+
+	tests := []struct {
+		name          string
+		options       DPoPOptions
+		authorization string
+		proofs        []string
+		expectedError error
+	}{
+		{
+			name:          "pass - valid proof",
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "dpop+jwt", proofClaims(boundToken))},
+		},
+		{
+			name:          "pass - htu with default port and query",
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "dpop+jwt", with(proofClaims(boundToken), "htu", "HTTPS://api.example.com:443/payments?id=1"))},
+		},
+		{
+			name:          "pass - bearer token not bound",
+			authorization: "Bearer " + unboundToken,
+		},
+		{
+			name:          "fail - bearer token not bound with DPoP required",
+			options:       DPoPOptions{Required: true},
+			authorization: "Bearer " + unboundToken,
+			expectedError: ErrMissingDPoPProof,
+		},
+		{
+			name:          "fail - bound token used as bearer token",
+			authorization: "Bearer " + boundToken,
+			expectedError: ErrDPoPBindingMismatch,
+		},
+		{
+			name:          "fail - missing proof",
+			authorization: "DPoP " + boundToken,
+			expectedError: ErrMissingDPoPProof,
+		},
+		{
+			name:          "fail - several proofs",
+			authorization: "DPoP " + boundToken,
+			proofs: []string{
+				getTestDPoPProof(proofKey, "dpop+jwt", proofClaims(boundToken)),
+				getTestDPoPProof(proofKey, "dpop+jwt", with(proofClaims(boundToken), "jti", "proof-2")),
+			},
+			expectedError: ErrMissingDPoPProof,
+		},
+		{
+			name:          "fail - proof of another key",
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(otherKey, "dpop+jwt", proofClaims(boundToken))},
+			expectedError: ErrDPoPBindingMismatch,
+		},
+		{
+			name:          "fail - unbound token with proof",
+			authorization: "DPoP " + unboundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "dpop+jwt", proofClaims(unboundToken))},
+			expectedError: ErrDPoPBindingMismatch,
+		},
+		{
+			name:          "fail - wrong type",
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "JWT", proofClaims(boundToken))},
+			expectedError: ErrInvalidDPoPProof,
+		},
+		{
+			name:          "fail - algorithm not allowed",
+			options:       DPoPOptions{Algorithms: []jose.SignatureAlgorithm{jose.RS256}},
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "dpop+jwt", proofClaims(boundToken))},
+			expectedError: ErrInvalidDPoPProof,
+		},
+		{
+			name:          "fail - wrong method",
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "dpop+jwt", with(proofClaims(boundToken), "htm", "GET"))},
+			expectedError: ErrInvalidDPoPProof,
+		},
+		{
+			name:          "fail - wrong URL",
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "dpop+jwt", with(proofClaims(boundToken), "htu", "https://api.example.com/refunds"))},
+			expectedError: ErrInvalidDPoPProof,
+		},
+		{
+			name:          "fail - proof too old",
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "dpop+jwt", with(proofClaims(boundToken), "iat", clock.Now().Add(-5*time.Minute).Unix()))},
+			expectedError: ErrInvalidDPoPProof,
+		},
+		{
+			name:          "fail - proof for another access token",
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "dpop+jwt", proofClaims(unboundToken))},
+			expectedError: ErrInvalidDPoPProof,
+		},
+		{
+			name:          "fail - no jti",
+			authorization: "DPoP " + boundToken,
+			proofs:        []string{getTestDPoPProof(proofKey, "dpop+jwt", with(proofClaims(boundToken), "jti", ""))},
+			expectedError: ErrInvalidDPoPProof,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configuration := NewConfigurationWithOptions(defaultSecretProvider, WithIssuer(defaultIssuer), WithClock(clock), WithDPoP(test.options))
+			validator := NewValidator(configuration, nil)
+
+			req := httptest.NewRequest("POST", "https://api.example.com/payments?id=1", nil)
+			req.Header.Set("Authorization", test.authorization)
+			for _, proof := range test.proofs {
+				req.Header.Add("DPoP", proof)
+			}
+
+			_, err := validator.ValidateRequest(req)
+			if test.expectedError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, test.expectedError), "expected %v, got %v", test.expectedError, err)
+				assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonInvalidDPoPProof}))
+			}
+		})
+	}
+}
+
+func TestDPoPProofReplay(t *testing.T) {
+	proofKey := genECDSAJWK(jose.ES256, "")
+	token := getTestTokenWithClaims(map[string]interface{}{
+		"iss": defaultIssuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"cnf": map[string]string{"jkt": getTestThumbprint(proofKey)},
+	}, jose.HS256, defaultSecret)
+	hash := sha256.Sum256([]byte(token))
+	proof := getTestDPoPProof(proofKey, "dpop+jwt", map[string]interface{}{
+		"jti": "proof-1",
+		"htm": "GET",
+		"htu": "http://api.example.com/internal",
+		"iat": time.Now().Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(hash[:]),
+	})
+
+	configuration := NewConfigurationWithOptions(defaultSecretProvider, WithDPoP(DPoPOptions{Required: true}))
+	validator := NewValidator(configuration, nil)
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://api.example.com/internal", nil)
+		req.Header.Set("Authorization", "DPoP "+token)
+		req.Header.Set("DPoP", proof)
+		return req
+	}
+
+	_, err := validator.ValidateRequest(newRequest())
+	assert.Nil(t, err)
+
+	_, err = validator.ValidateRequest(newRequest())
+	assert.True(t, errors.Is(err, ErrInvalidDPoPProof), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), ErrTokenReplayed.Error())
+
+	rec := httptest.NewRecorder()
+	validator.Middleware()(nil).ServeHTTP(rec, newRequest())
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `DPoP error="invalid_dpop_proof"`, rec.Header().Get("WWW-Authenticate"))
+}
+
+func TestDPoPNotEnabled(t *testing.T) {
+	proofKey := genECDSAJWK(jose.ES256, "")
+	claims := map[string]interface{}{
+		"iss": defaultIssuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	unboundToken := getTestTokenWithClaims(claims, jose.HS256, defaultSecret)
+	claims["cnf"] = map[string]string{"jkt": getTestThumbprint(proofKey)}
+	boundToken := getTestTokenWithClaims(claims, jose.HS256, defaultSecret)
+
+	tests := []struct {
+		name           string
+		authorization  string
+		expectedReason Reason
+	}{
+		{
+			name:          "pass - bearer token",
+			authorization: "Bearer " + unboundToken,
+		},
+		{
+			name:           "fail - DPoP scheme",
+			authorization:  "DPoP " + unboundToken,
+			expectedReason: ReasonMalformed,
+		},
+		{
+			name:           "fail - DPoP-bound token",
+			authorization:  "Bearer " + boundToken,
+			expectedReason: ReasonInvalidClaims,
+		},
+	}
+
+	validator := NewValidator(NewConfigurationWithOptions(defaultSecretProvider, WithIssuer(defaultIssuer)), nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://api.example.com/internal", nil)
+			req.Header.Set("Authorization", test.authorization)
+
+			_, err := validator.ValidateRequest(req)
+			if test.expectedReason == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrDPoPNotEnabled), "unexpected error: %v", err)
+				assert.True(t, errors.Is(err, &ValidationError{Reason: test.expectedReason}))
+			}
+		})
+	}
+}
+
+func TestDPoPDefaultReplayCacheSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      []ConfigOption
+		expectedSize int
+	}{
+		{
+			name:         "default max age and leeway",
+			options:      []ConfigOption{WithDPoP(DPoPOptions{})},
+			expectedSize: 120 * DefaultDPoPProofRate,
+		},
+		{
+			name:         "custom max age and leeway",
+			options:      []ConfigOption{WithLeeway(0), WithDPoP(DPoPOptions{MaxAge: 5 * time.Minute})},
+			expectedSize: 300 * DefaultDPoPProofRate,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configuration := NewConfigurationWithOptions(defaultSecretProvider, test.options...)
+			assert.Equal(t, test.expectedSize, configuration.dpop.ReplayCache.(*memoryReplayCache).maxSize)
+		})
+	}
+}
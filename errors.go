@@ -0,0 +1,125 @@
+package auth0
+
+import (
+	"errors"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Reason tells why a token failed validation.
+type Reason string
+
+const (
+	// ReasonMissing means no token was found in the request.
+	ReasonMissing Reason = "missing"
+	// ReasonMalformed means the token could not be parsed.
+	ReasonMalformed Reason = "malformed"
+	// ReasonInvalidAlgorithm means the token algorithm is not allowed
+	// or does not match the key provided for it.
+	ReasonInvalidAlgorithm Reason = "invalid_algorithm"
+	// ReasonDecryptionFailed means the encrypted token could not be decrypted.
+	ReasonDecryptionFailed Reason = "decryption_failed"
+	// ReasonBadSignature means the token signature could not be verified.
+	ReasonBadSignature Reason = "bad_signature"
+	// ReasonExpired means the token is past its exp claim.
+	ReasonExpired Reason = "expired"
+	// ReasonNotYetValid means the token is used before its nbf
+	// claim, or was issued in the future.
+	ReasonNotYetValid Reason = "not_yet_valid"
+	// ReasonWrongAudience means the token was issued for other audiences.
+	ReasonWrongAudience Reason = "wrong_audience"
+	// ReasonWrongIssuer means the token was issued by another issuer.
+	ReasonWrongIssuer Reason = "wrong_issuer"
+	// ReasonInvalidClaims means other claims of the token are invalid.
+	ReasonInvalidClaims Reason = "invalid_claims"
+	// ReasonReplayed means the token was already used.
+	ReasonReplayed Reason = "replayed"
+	// ReasonRevoked means the token has been revoked,
+	// or its revocation could not be checked.
+	ReasonRevoked Reason = "revoked"
+	// ReasonInvalidDPoPProof means the DPoP proof of the request is missing
+	// or invalid, or does not match the key the token is bound to.
+	ReasonInvalidDPoPProof Reason = "invalid_dpop_proof"
+	// ReasonCertificateMismatch means the token is bound to another
+	// certificate than the client certificate of the connection.
+	ReasonCertificateMismatch Reason = "certificate_mismatch"
+	// ReasonUnknownKID means no key is known for the token key ID.
+	ReasonUnknownKID Reason = "unknown_kid"
+	// ReasonKeyFetchFailed means the key of the token could not be retrieved.
+	ReasonKeyFetchFailed Reason = "key_fetch_failed"
+)
+
+// ValidationError is returned when a request or a token fails
+// validation. It wraps the underlying error, so that both the
+// Reason and the cause can be checked with errors.Is and errors.As.
+type ValidationError struct {
+	Reason Reason
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	return string(e.Reason) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is a *ValidationError with the same Reason,
+// so that errors.Is(err, &ValidationError{Reason: ReasonExpired}) works.
+func (e *ValidationError) Is(target error) bool {
+	t, ok := target.(*ValidationError)
+	return ok && t.Reason == e.Reason && t.Err == nil
+}
+
+func newValidationError(reason Reason, err error) error {
+	if err == nil {
+		return nil
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return err
+	}
+	return &ValidationError{Reason: reason, Err: err}
+}
+
+// extractionReason classifies the errors of a RequestTokenExtractor.
+func extractionReason(err error) Reason {
+	switch {
+	case errors.Is(err, ErrTokenNotFound):
+		return ReasonMissing
+	case errors.Is(err, ErrNoDecryptionKeyProvider), errors.Is(err, ErrDecryptionFailed):
+		return ReasonDecryptionFailed
+	}
+	return ReasonMalformed
+}
+
+// keyReason classifies the errors of a SecretProvider.
+func keyReason(err error) Reason {
+	if errors.Is(err, ErrNoKeyFound) || errors.Is(err, ErrKeyLookupSuppressed) {
+		return ReasonUnknownKID
+	}
+	return ReasonKeyFetchFailed
+}
+
+// claimsReason classifies the errors of the claims verification and validation.
+func claimsReason(err error) Reason {
+	switch {
+	case errors.Is(err, jose.ErrCryptoFailure):
+		return ReasonBadSignature
+	case errors.Is(err, jwt.ErrExpired), errors.Is(err, ErrTokenTooOld):
+		return ReasonExpired
+	case errors.Is(err, jwt.ErrNotValidYet), errors.Is(err, ErrIssuedInTheFuture):
+		return ReasonNotYetValid
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		return ReasonWrongAudience
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		return ReasonWrongIssuer
+	case errors.Is(err, jwt.ErrInvalidSubject), errors.Is(err, jwt.ErrInvalidID), errors.Is(err, ErrMissingRequiredClaim),
+		errors.Is(err, ErrMissingExpiry), errors.Is(err, ErrMissingIssuedAt), errors.Is(err, ErrLifetimeTooLong):
+		return ReasonInvalidClaims
+	}
+	return ReasonMalformed
+}
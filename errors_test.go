@@ -0,0 +1,135 @@
+package auth0
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestValidationErrorReasons(t *testing.T) {
+	opts, _, _, err := genNewTestServer(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwkConfiguration := NewConfiguration(NewJWKClient(opts, nil), defaultAudience, defaultIssuer, jose.ES384)
+	configuration := NewConfiguration(defaultSecretProvider, defaultAudience, defaultIssuer, jose.HS256)
+	future := time.Now().Add(24 * time.Hour)
+
+	tests := []struct {
+		name           string
+		configuration  Configuration
+		token          string
+		expectedReason Reason
+		expectedCause  error
+	}{
+		{
+			name:           "missing",
+			configuration:  configuration,
+			expectedReason: ReasonMissing,
+			expectedCause:  ErrTokenNotFound,
+		},
+		{
+			name:           "malformed",
+			configuration:  configuration,
+			token:          "not.a.token",
+			expectedReason: ReasonMalformed,
+		},
+		{
+			name:           "invalid algorithm",
+			configuration:  configuration,
+			token:          getTestToken(defaultAudience, defaultIssuer, future, jose.HS384, defaultSecret),
+			expectedReason: ReasonInvalidAlgorithm,
+			expectedCause:  ErrInvalidAlgorithm,
+		},
+		{
+			name:           "bad signature",
+			configuration:  configuration,
+			token:          getTestToken(defaultAudience, defaultIssuer, future, jose.HS256, []byte("invalid secret")),
+			expectedReason: ReasonBadSignature,
+			expectedCause:  jose.ErrCryptoFailure,
+		},
+		{
+			name:           "expired",
+			configuration:  configuration,
+			token:          getTestToken(defaultAudience, defaultIssuer, time.Now().Add(-24*time.Hour), jose.HS256, defaultSecret),
+			expectedReason: ReasonExpired,
+			expectedCause:  jwt.ErrExpired,
+		},
+		{
+			name:          "not yet valid",
+			configuration: configuration,
+			token: getTestTokenWithClaims(jwt.Claims{
+				Issuer:    defaultIssuer,
+				Audience:  defaultAudience,
+				NotBefore: jwt.NewNumericDate(future),
+				Expiry:    jwt.NewNumericDate(future.Add(time.Hour)),
+			}, jose.HS256, defaultSecret),
+			expectedReason: ReasonNotYetValid,
+			expectedCause:  jwt.ErrNotValidYet,
+		},
+		{
+			name:           "wrong audience",
+			configuration:  configuration,
+			token:          getTestToken([]string{"invalid aud"}, defaultIssuer, future, jose.HS256, defaultSecret),
+			expectedReason: ReasonWrongAudience,
+			expectedCause:  jwt.ErrInvalidAudience,
+		},
+		{
+			name:           "wrong issuer",
+			configuration:  configuration,
+			token:          getTestToken(defaultAudience, "invalid iss", future, jose.HS256, defaultSecret),
+			expectedReason: ReasonWrongIssuer,
+			expectedCause:  jwt.ErrInvalidIssuer,
+		},
+		{
+			name:           "unknown kid",
+			configuration:  jwkConfiguration,
+			token:          getTestTokenWithKidString(defaultAudience, defaultIssuer, future, jose.ES384, genECDSAJWK(jose.ES384, "unknown"), "unknown"),
+			expectedReason: ReasonUnknownKID,
+			expectedCause:  ErrNoKeyFound,
+		},
+		{
+			name:           "key fetch failed",
+			configuration:  NewConfiguration(SecretProviderFunc(invalidProvider), defaultAudience, defaultIssuer, jose.HS256),
+			token:          getTestToken(defaultAudience, defaultIssuer, future, jose.HS256, defaultSecret),
+			expectedReason: ReasonKeyFetchFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := NewValidator(test.configuration, nil)
+			req, _ := http.NewRequest("", "http://localhost", nil)
+			if test.token != "" {
+				req.Header.Add("Authorization", "Bearer "+test.token)
+			}
+
+			_, err := validator.ValidateRequest(req)
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validation should have failed with a ValidationError, but got: %v", err)
+			}
+			assert.Equal(t, test.expectedReason, validationErr.Reason)
+			assert.True(t, errors.Is(err, &ValidationError{Reason: test.expectedReason}))
+			if test.expectedCause != nil {
+				assert.True(t, errors.Is(err, test.expectedCause), "expected cause %v, got %v", test.expectedCause, err)
+			}
+		})
+	}
+}
+
+func TestValidationErrorIs(t *testing.T) {
+	err := &ValidationError{Reason: ReasonExpired, Err: jwt.ErrExpired}
+
+	assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonExpired}))
+	assert.False(t, errors.Is(err, &ValidationError{Reason: ReasonMalformed}))
+	assert.True(t, errors.Is(err, jwt.ErrExpired))
+	assert.Equal(t, "expired: "+jwt.ErrExpired.Error(), err.Error())
+	assert.Equal(t, err, newValidationError(ReasonMalformed, err), "an existing reason should be kept")
+}
@@ -1,13 +1,16 @@
 package auth0
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"gopkg.in/square/go-jose.v2/jwt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/square/go-jose.v2"
 )
@@ -15,11 +18,95 @@ import (
 var (
 	ErrInvalidContentType = errors.New("should have a JSON content type for JWKS endpoint")
 	ErrInvalidAlgorithm   = errors.New("algorithm is invalid")
+	// ErrKeyLookupSuppressed is returned by GetKey when the key ID is known to be
+	// absent from the JWKS, or when the last download is too recent to try again.
+	ErrKeyLookupSuppressed = errors.New("key lookup suppressed to protect the JWKS endpoint")
 )
 
+const (
+	// DefaultKeySetLifetime is how long a downloaded key set is considered
+	// fresh when the JWKS response has no Cache-Control or Expires header.
+	DefaultKeySetLifetime = time.Hour
+	// DefaultRefreshAhead is how long before the key set expires
+	// the background refresh downloads it again.
+	DefaultRefreshAhead = time.Minute
+	// DefaultMinDownloadInterval is the default minimum time between two
+	// downloads triggered by key lookups while the key set is fresh.
+	DefaultMinDownloadInterval = 10 * time.Second
+)
+
+// maxUnknownKeys bounds the number of key IDs remembered as unknown.
+const maxUnknownKeys = 1024
+
+// minRefreshWait bounds the background refresh rate when the
+// JWKS endpoint advertises very short lifetimes or is down.
+const minRefreshWait = 10 * time.Second
+
+// newRefreshTimer starts the wait before the next background refresh,
+// returning the channel the refresh time is sent on and a stop function.
+func newRefreshTimer(wait time.Duration) (<-chan time.Time, func() bool) {
+	timer := time.NewTimer(wait)
+	return timer.C, timer.Stop
+}
+
 type JWKClientOptions struct {
 	URI    string
 	Client *http.Client
+	// BackgroundRefresh starts a goroutine downloading the key set before
+	// it expires, so that rotated keys are known before tokens use them.
+	// Call Close to stop it.
+	BackgroundRefresh bool
+	// RefreshAhead is how long before expiry the key set is refreshed.
+	// Defaults to DefaultRefreshAhead.
+	RefreshAhead time.Duration
+	// KeySetLifetime is used when the JWKS response has no caching headers.
+	// Defaults to DefaultKeySetLifetime.
+	KeySetLifetime time.Duration
+	// NegativeCacheTTL is how long a key ID missing from a downloaded key set
+	// is remembered, during which its lookups fail without any download.
+	// Zero disables the negative cache.
+	NegativeCacheTTL time.Duration
+	// MinDownloadInterval is the minimum time between two downloads triggered
+	// by key lookups while the current key set is fresh, so that tokens
+	// carrying random key IDs cannot trigger a download each. Defaults to
+	// DefaultMinDownloadInterval, a negative value disables the limit.
+	MinDownloadInterval time.Duration
+	// Clock provides the time the key set lifetime and the download
+	// limits are checked against. Defaults to the system clock.
+	Clock Clock
+	// ConnectTimeout bounds the connection to the JWKS endpoint, and Timeout
+	// each request to it. They default to DefaultJWKSConnectTimeout and
+	// DefaultJWKSTimeout, and only apply when Client is nil, in which case
+	// redirects are only followed to the host of the URI.
+	ConnectTimeout time.Duration
+	Timeout        time.Duration
+	// MaxResponseSize is the maximum size of the JWKS response body.
+	// Defaults to DefaultMaxJWKSSize.
+	MaxResponseSize int64
+	// Retries is the number of times a download failing with a 5xx status
+	// or a timeout is retried, waiting RetryBackoff before the first retry
+	// and twice as long before each other one, with jitter. They default to
+	// DefaultJWKSRetries and DefaultJWKSRetryBackoff. A negative Retries
+	// disables the retries.
+	Retries      int
+	RetryBackoff time.Duration
+	// CircuitBreakerThreshold is the number of consecutive failed downloads
+	// after which downloads fail with ErrJWKSCircuitOpen for
+	// CircuitBreakerCooldown, before a single download probes the endpoint
+	// again. They default to DefaultCircuitBreakerThreshold and
+	// DefaultCircuitBreakerCooldown. A negative threshold disables it.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+	// StaleKeyGracePeriod is how long a key no longer published in the JWKS
+	// keeps being served from the cache, when the KeyCacher implements
+	// KeyRemover. Zero removes it as soon as the key set is downloaded,
+	// which happens with BackgroundRefresh before the key set expires,
+	// and otherwise in the background after the first lookup of an
+	// expired key set.
+	StaleKeyGracePeriod time.Duration
+	// OnKeyRemoved is called with the ID of each key removed from the cache
+	// because it is no longer published in the JWKS.
+	OnKeyRemoved func(keyID string)
 }
 
 type JWKS struct {
@@ -28,9 +115,53 @@ type JWKS struct {
 
 type JWKClient struct {
 	keyCacher KeyCacher
+	// cacheMu serializes the additions to and removals from keyCacher.
+	cacheMu   sync.Mutex
 	mu        sync.Mutex
 	options   JWKClientOptions
 	extractor RequestTokenExtractor
+	// mu guards keySet, the last key set successfully
+	// downloaded, the download limits and staleKeys.
+	keySet      *keySet
+	unknownKeys map[string]time.Time
+	// staleKeys maps the IDs of the keys no longer published
+	// to when they are removed from the cache, nextStaleRemoval
+	// being the earliest of these times in Unix nanoseconds, read
+	// without the lock, or zero when there are none.
+	staleKeys        map[string]time.Time
+	nextStaleRemoval int64
+	lastDownload     time.Time
+	flights          keyFlightGroup
+	breaker          circuitBreaker
+	// refreshing is set while the expired key set is downloaded again
+	// for the lookups, refreshes tracking these downloads, which are
+	// not started before refreshAfter in Unix nanoseconds.
+	refreshing   int32
+	refreshes    sync.WaitGroup
+	refreshAfter int64
+	stop         chan struct{}
+	stopOnce     sync.Once
+	// newTimer starts the waits of the background refresh,
+	// with newRefreshTimer unless overridden by the tests.
+	newTimer func(wait time.Duration) (<-chan time.Time, func() bool)
+}
+
+type keySet struct {
+	keys      []jose.JSONWebKey
+	expiresAt time.Time
+	// etag and lastModified are the validators of the JWKS
+	// response, sent back to only download modified key sets.
+	etag         string
+	lastModified string
+}
+
+func (ks *keySet) key(keyID string) (jose.JSONWebKey, bool) {
+	for _, key := range ks.keys {
+		if key.KeyID == keyID {
+			return key, true
+		}
+	}
+	return jose.JSONWebKey{}, false
 }
 
 // NewJWKClient creates a new JWKClient instance from the
@@ -49,58 +180,316 @@ func NewJWKClientWithCache(options JWKClientOptions, extractor RequestTokenExtra
 	if keyCacher == nil {
 		keyCacher = newMemoryPersistentKeyCacher()
 	}
-	if options.Client == nil {
-		options.Client = http.DefaultClient
+	options = options.withDefaults()
+
+	client := &JWKClient{
+		keyCacher:   keyCacher,
+		options:     options,
+		extractor:   extractor,
+		stop:        make(chan struct{}),
+		newTimer:    newRefreshTimer,
+		unknownKeys: map[string]time.Time{},
+		staleKeys:   map[string]time.Time{},
+		breaker: circuitBreaker{
+			threshold: options.CircuitBreakerThreshold,
+			cooldown:  options.CircuitBreakerCooldown,
+		},
 	}
+	if options.BackgroundRefresh {
+		go client.refreshLoop()
+	}
+	return client
+}
 
-	return &JWKClient{
-		keyCacher: keyCacher,
-		options:   options,
-		extractor: extractor,
+// withDefaults returns the options with the defaults set
+// for the fields left to their zero value.
+func (options JWKClientOptions) withDefaults() JWKClientOptions {
+	if options.ConnectTimeout == 0 {
+		options.ConnectTimeout = DefaultJWKSConnectTimeout
+	}
+	if options.Timeout == 0 {
+		options.Timeout = DefaultJWKSTimeout
+	}
+	if options.Client == nil {
+		options.Client = newJWKSHTTPClient(options)
+	}
+	if options.MaxResponseSize == 0 {
+		options.MaxResponseSize = DefaultMaxJWKSSize
+	}
+	if options.Retries == 0 {
+		options.Retries = DefaultJWKSRetries
+	}
+	if options.RetryBackoff == 0 {
+		options.RetryBackoff = DefaultJWKSRetryBackoff
+	}
+	if options.CircuitBreakerThreshold == 0 {
+		options.CircuitBreakerThreshold = DefaultCircuitBreakerThreshold
+	}
+	if options.CircuitBreakerCooldown == 0 {
+		options.CircuitBreakerCooldown = DefaultCircuitBreakerCooldown
+	}
+	if options.MinDownloadInterval == 0 {
+		options.MinDownloadInterval = DefaultMinDownloadInterval
+	}
+	if options.RefreshAhead == 0 {
+		options.RefreshAhead = DefaultRefreshAhead
+	}
+	if options.KeySetLifetime == 0 {
+		options.KeySetLifetime = DefaultKeySetLifetime
 	}
+	if options.Clock == nil {
+		options.Clock = systemClock
+	}
+	return options
+}
+
+// Close stops the background refresh of the key set.
+func (j *JWKClient) Close() {
+	j.stopOnce.Do(func() {
+		close(j.stop)
+	})
 }
 
 // GetKey returns the key associated with the provided ID.
+// Keys missing from the cache are looked up in the current key set, which is
+// downloaded again when it is stale or does not know the ID. Concurrent
+// lookups of the same ID share a single download, and the last good key set
+// keeps being used while the JWKS endpoint is unavailable.
+// ErrKeyLookupSuppressed is returned when the download limits set in the
+// JWKClientOptions prevent the lookup.
 func (j *JWKClient) GetKey(ID string) (jose.JSONWebKey, error) {
-	searchedKey, err := j.keyCacher.Get(ID)
+	return j.GetKeyContext(context.Background(), ID)
+}
+
+// GetKeyContext returns the key associated with the provided ID like
+// GetKey, downloading the key set and using the cache with ctx.
+func (j *JWKClient) GetKeyContext(ctx context.Context, ID string) (jose.JSONWebKey, error) {
+	j.removeDueStaleKeys()
+
+	searchedKey, err := getCachedKey(ctx, j.keyCacher, ID)
+	if err == nil {
+		j.refreshExpiredKeySet()
+		return *searchedKey, nil
+	}
+
+	addedKey, err := j.flights.do(ctx, ID, func() (*jose.JSONWebKey, error) {
+		current := j.currentKeySet()
+		if current != nil && j.options.Clock.Now().Before(current.expiresAt) {
+			if _, ok := current.key(ID); ok {
+				return j.addKey(ctx, ID)
+			}
+		}
 
+		if err := j.downloadKeysFor(ctx, ID); err != nil {
+			if current == nil {
+				return nil, err
+			}
+			if _, ok := current.key(ID); !ok {
+				return nil, err
+			}
+		}
+		return j.addKey(ctx, ID)
+	})
 	if err != nil {
-		j.mu.Lock()
-		defer j.mu.Unlock()
+		return jose.JSONWebKey{}, err
+	}
+	return *addedKey, nil
+}
+
+// refreshExpiredKeySet starts downloading the key set again once it has
+// expired, so that the keys no longer published are removed from the cache
+// even when every lookup hits it. The lookups keep being served from the
+// cache meanwhile, a single download running at a time, at most once
+// every minRefreshWait.
+func (j *JWKClient) refreshExpiredKeySet() {
+	if _, ok := j.keyCacher.(KeyRemover); !ok && j.options.OnKeyRemoved == nil {
+		return
+	}
+	now := j.options.Clock.Now()
+	if now.UnixNano() < atomic.LoadInt64(&j.refreshAfter) {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&j.refreshing, 0, 1) {
+		return
+	}
+	// set to the expiry of the key set once downloaded
+	atomic.StoreInt64(&j.refreshAfter, now.Add(minRefreshWait).UnixNano())
+
+	j.refreshes.Add(1)
+	go func() {
+		defer j.refreshes.Done()
+		defer atomic.StoreInt32(&j.refreshing, 0)
 
-		keys, err := j.downloadKeys()
-		if err != nil {
-			return jose.JSONWebKey{}, err
+		if j.allowRefresh() {
+			// on failure the keys keep being served from the cache
+			j.downloadKeysContext(context.Background())
 		}
-		addedKey, err := j.keyCacher.Add(ID, keys)
-		if err != nil {
-			return jose.JSONWebKey{}, err
+	}()
+}
+
+func (j *JWKClient) allowRefresh() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.options.Clock.Now()
+	if j.keySet != nil && now.Before(j.keySet.expiresAt) {
+		return false
+	}
+	if now.Before(j.lastDownload.Add(minRefreshWait)) {
+		return false
+	}
+	j.lastDownload = now
+	return true
+}
+
+// addKey adds the key to the cache from the current key set. The additions
+// are serialized with the removals, so that a key removed meanwhile is not
+// added back from the key set it was published in.
+func (j *JWKClient) addKey(ctx context.Context, ID string) (*jose.JSONWebKey, error) {
+	j.cacheMu.Lock()
+	defer j.cacheMu.Unlock()
+
+	current := j.currentKeySet()
+	if current == nil {
+		return nil, ErrNoKeyFound
+	}
+	return addCachedKey(ctx, j.keyCacher, ID, current.keys)
+}
+
+// downloadKeysFor downloads the key set to look up ID, unless ID is known
+// to be missing from the JWKS or the previous download is too recent.
+func (j *JWKClient) downloadKeysFor(ctx context.Context, ID string) error {
+	if !j.allowDownload(ID) {
+		return ErrKeyLookupSuppressed
+	}
+
+	keys, err := j.downloadKeysContext(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := (&keySet{keys: keys}).key(ID); !ok {
+		j.rememberUnknownKey(ID)
+	}
+	return nil
+}
+
+func (j *JWKClient) allowDownload(ID string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.options.Clock.Now()
+	if expiresAt, ok := j.unknownKeys[ID]; ok {
+		if now.Before(expiresAt) {
+			return false
 		}
-		return *addedKey, nil
+		delete(j.unknownKeys, ID)
+	}
+	fresh := j.keySet != nil && now.Before(j.keySet.expiresAt)
+	if fresh && now.Before(j.lastDownload.Add(j.options.MinDownloadInterval)) {
+		return false
+	}
+	j.lastDownload = now
+	return true
+}
+
+func (j *JWKClient) rememberUnknownKey(ID string) {
+	if j.options.NegativeCacheTTL <= 0 {
+		return
 	}
 
-	return *searchedKey, nil
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.options.Clock.Now()
+	if len(j.unknownKeys) >= maxUnknownKeys {
+		for unknownID, expiresAt := range j.unknownKeys {
+			if !now.Before(expiresAt) {
+				delete(j.unknownKeys, unknownID)
+			}
+		}
+	}
+	// When still full, MinDownloadInterval is left to limit the downloads.
+	if len(j.unknownKeys) < maxUnknownKeys {
+		j.unknownKeys[ID] = now.Add(j.options.NegativeCacheTTL)
+	}
 }
 
+func (j *JWKClient) currentKeySet() *keySet {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.keySet
+}
+
+func (j *JWKClient) refreshLoop() {
+	// cancel the download in progress when the client is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-j.stop
+		cancel()
+	}()
+
+	wait := time.Duration(0)
+	for {
+		refresh, stopTimer := j.newTimer(wait)
+		select {
+		case <-j.stop:
+			stopTimer()
+			return
+		case <-refresh:
+		}
+
+		// On failure the last good key set is kept and
+		// the download is retried after minRefreshWait.
+		j.downloadKeysContext(ctx)
+
+		wait = minRefreshWait
+		if current := j.currentKeySet(); current != nil {
+			if untilRefresh := current.expiresAt.Add(-j.options.RefreshAhead).Sub(j.options.Clock.Now()); untilRefresh > wait {
+				wait = untilRefresh
+			}
+		}
+	}
+}
+
+// downloadKeys downloads the key set and keeps
+// it as the current one when it is valid.
 func (j *JWKClient) downloadKeys() ([]jose.JSONWebKey, error) {
-	req, err := http.NewRequest("GET", j.options.URI, new(bytes.Buffer))
+	return j.downloadKeysContext(context.Background())
+}
+
+// downloadKeysContext downloads the key set like downloadKeys, with ctx.
+// The request is conditional on the ETag and Last-Modified date of the
+// current key set, whose lifetime is extended when it is not modified.
+func (j *JWKClient) downloadKeysContext(ctx context.Context) ([]jose.JSONWebKey, error) {
+	current := j.currentKeySet()
+	resp, err := j.fetchKeySet(ctx, current)
 	if err != nil {
 		return []jose.JSONWebKey{}, err
 	}
-	resp, err := j.options.Client.Do(req)
 
-	if err != nil {
-		return []jose.JSONWebKey{}, err
+	if resp.notModified {
+		// the keys are unchanged, only their lifetime is extended
+		j.mu.Lock()
+		j.keySet = &keySet{
+			keys:         current.keys,
+			expiresAt:    keySetExpiry(resp.header, j.options.Clock.Now(), j.options.KeySetLifetime),
+			etag:         headerOr(resp.header, "ETag", current.etag),
+			lastModified: headerOr(resp.header, "Last-Modified", current.lastModified),
+		}
+		atomic.StoreInt64(&j.refreshAfter, j.keySet.expiresAt.UnixNano())
+		j.mu.Unlock()
+
+		return current.keys, nil
 	}
-	defer resp.Body.Close()
 
-	if contentH := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentH, "application/json") &&
+	if contentH := resp.header.Get("Content-Type"); !strings.HasPrefix(contentH, "application/json") &&
 		!strings.HasPrefix(contentH, "application/jwk-set+json") {
 		return []jose.JSONWebKey{}, ErrInvalidContentType
 	}
 
 	var jwks = JWKS{}
-	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	err = json.Unmarshal(resp.body, &jwks)
 
 	if err != nil {
 		return []jose.JSONWebKey{}, err
@@ -110,16 +499,210 @@ func (j *JWKClient) downloadKeys() ([]jose.JSONWebKey, error) {
 		return []jose.JSONWebKey{}, ErrNoKeyFound
 	}
 
+	j.mu.Lock()
+	previous := j.keySet
+	j.keySet = &keySet{
+		keys:         jwks.Keys,
+		expiresAt:    keySetExpiry(resp.header, j.options.Clock.Now(), j.options.KeySetLifetime),
+		etag:         resp.header.Get("ETag"),
+		lastModified: resp.header.Get("Last-Modified"),
+	}
+	atomic.StoreInt64(&j.refreshAfter, j.keySet.expiresAt.UnixNano())
+	j.scheduleStaleKeys(previous, j.keySet)
+	j.mu.Unlock()
+
+	j.removeDueStaleKeys()
+
 	return jwks.Keys, nil
 }
 
+// scheduleStaleKeys schedules the removal from the cache of the keys of
+// previous missing from next, and cancels it for the keys of next.
+// j.mu must be held.
+func (j *JWKClient) scheduleStaleKeys(previous, next *keySet) {
+	defer j.updateNextStaleRemoval()
+
+	for _, key := range next.keys {
+		delete(j.staleKeys, key.KeyID)
+	}
+	if previous == nil {
+		return
+	}
+	removeAt := j.options.Clock.Now().Add(j.options.StaleKeyGracePeriod)
+	for _, key := range previous.keys {
+		if _, ok := next.key(key.KeyID); ok {
+			continue
+		}
+		if _, ok := j.staleKeys[key.KeyID]; !ok {
+			j.staleKeys[key.KeyID] = removeAt
+		}
+	}
+}
+
+// updateNextStaleRemoval sets nextStaleRemoval from staleKeys.
+// j.mu must be held.
+func (j *JWKClient) updateNextStaleRemoval() {
+	var next int64
+	for _, removeAt := range j.staleKeys {
+		if at := removeAt.UnixNano(); next == 0 || at < next {
+			next = at
+		}
+	}
+	atomic.StoreInt64(&j.nextStaleRemoval, next)
+}
+
+// removeDueStaleKeys removes the stale keys whose grace period is over,
+// only taking the lock when one of them may be.
+func (j *JWKClient) removeDueStaleKeys() {
+	next := atomic.LoadInt64(&j.nextStaleRemoval)
+	if next == 0 || j.options.Clock.Now().UnixNano() < next {
+		return
+	}
+	j.removeKeys(j.dueStaleKeys())
+}
+
+// dueStaleKeys returns the IDs of the stale keys whose grace
+// period is over, and forgets them.
+func (j *JWKClient) dueStaleKeys() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.staleKeys) == 0 {
+		return nil
+	}
+	now := j.options.Clock.Now()
+	var due []string
+	for keyID, removeAt := range j.staleKeys {
+		if !now.Before(removeAt) {
+			due = append(due, keyID)
+			delete(j.staleKeys, keyID)
+		}
+	}
+	j.updateNextStaleRemoval()
+	return due
+}
+
+// removeKeys removes the keys from the cache when
+// it is a KeyRemover, and reports their removal.
+func (j *JWKClient) removeKeys(keyIDs []string) {
+	remover, ok := j.keyCacher.(KeyRemover)
+	for _, keyID := range keyIDs {
+		if ok {
+			j.removeKey(remover, keyID)
+		}
+		if j.options.OnKeyRemoved != nil {
+			j.options.OnKeyRemoved(keyID)
+		}
+	}
+}
+
+func (j *JWKClient) removeKey(remover KeyRemover, keyID string) {
+	j.cacheMu.Lock()
+	defer j.cacheMu.Unlock()
+	remover.Remove(keyID)
+}
+
+// keySetExpiry computes when a key set expires from the
+// Cache-Control and Expires headers of the JWKS response.
+func keySetExpiry(header http.Header, now time.Time, defaultLifetime time.Duration) time.Time {
+	if cacheControl := header.Get("Cache-Control"); cacheControl != "" {
+		for _, directive := range strings.Split(cacheControl, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if directive == "no-cache" || directive == "no-store" {
+				return now
+			}
+			if strings.HasPrefix(directive, "max-age=") {
+				maxAge, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+				if err != nil || maxAge < 0 {
+					continue
+				}
+				age, _ := strconv.Atoi(header.Get("Age"))
+				return now.Add(time.Duration(maxAge-age) * time.Second)
+			}
+		}
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			return t
+		}
+		// Invalid dates such as "0" mean already expired.
+		return now
+	}
+	return now.Add(defaultLifetime)
+}
+
+// headerOr returns the value of the header key, or value when it is absent.
+func headerOr(header http.Header, key, value string) string {
+	if v := header.Get(key); v != "" {
+		return v
+	}
+	return value
+}
+
 // GetSecret implements the GetSecret method of the SecretProvider interface.
 func (j *JWKClient) GetSecret(token *jwt.JSONWebToken) (interface{}, error) {
+	return j.GetSecretContext(context.Background(), token)
+}
+
+// GetSecretContext implements the ContextSecretProvider interface.
+func (j *JWKClient) GetSecretContext(ctx context.Context, token *jwt.JSONWebToken) (interface{}, error) {
 	if len(token.Headers) < 1 {
 		return nil, ErrNoJWTHeaders
 	}
 
 	header := token.Headers[0]
 
-	return j.GetKey(header.KeyID)
+	return j.GetKeyContext(ctx, header.KeyID)
+}
+
+// keyFlightGroup makes concurrent lookups of the same
+// key ID wait for a single download.
+type keyFlightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*keyFlight
+}
+
+type keyFlight struct {
+	done chan struct{}
+	key  *jose.JSONWebKey
+	err  error
+	// canceled tells whether the context of the lookup was done
+	canceled bool
+}
+
+// do runs fn, the lookup of keyID with ctx, unless a lookup of keyID is
+// already in progress, in which case its result is waited for until ctx
+// is done. A lookup canceled by the context of another caller is retried.
+func (g *keyFlightGroup) do(ctx context.Context, keyID string, fn func() (*jose.JSONWebKey, error)) (*jose.JSONWebKey, error) {
+	for {
+		g.mu.Lock()
+		if g.calls == nil {
+			g.calls = map[string]*keyFlight{}
+		}
+		if call, ok := g.calls[keyID]; ok {
+			g.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if call.canceled && ctx.Err() == nil {
+				continue
+			}
+			return call.key, call.err
+		}
+		call := &keyFlight{done: make(chan struct{})}
+		g.calls[keyID] = call
+		g.mu.Unlock()
+
+		call.key, call.err = fn()
+		call.canceled = call.err != nil && ctx.Err() != nil
+		close(call.done)
+
+		g.mu.Lock()
+		delete(g.calls, keyID)
+		g.mu.Unlock()
+
+		return call.key, call.err
+	}
 }
@@ -1,12 +1,15 @@
 package auth0
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"gopkg.in/square/go-jose.v2/jwt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -279,3 +282,573 @@ func (m *mockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 	atomic.AddUint64(m.ops, 1)
 	return m.rt.RoundTrip(req)
 }
+
+func TestKeySetExpiry(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		header         http.Header
+		expectedExpiry time.Time
+	}{
+		{
+			name:           "no caching headers",
+			header:         http.Header{},
+			expectedExpiry: now.Add(DefaultKeySetLifetime),
+		},
+		{
+			name:           "max-age",
+			header:         http.Header{"Cache-Control": {"public, max-age=600"}},
+			expectedExpiry: now.Add(10 * time.Minute),
+		},
+		{
+			name:           "max-age with age",
+			header:         http.Header{"Cache-Control": {"max-age=600"}, "Age": {"60"}},
+			expectedExpiry: now.Add(9 * time.Minute),
+		},
+		{
+			name:           "no-cache",
+			header:         http.Header{"Cache-Control": {"no-cache"}},
+			expectedExpiry: now,
+		},
+		{
+			name:           "expires",
+			header:         http.Header{"Expires": {"Tue, 01 Oct 2019 13:00:00 GMT"}},
+			expectedExpiry: now.Add(time.Hour),
+		},
+		{
+			name:           "invalid expires",
+			header:         http.Header{"Expires": {"0"}},
+			expectedExpiry: now,
+		},
+		{
+			name:           "max-age takes precedence over expires",
+			header:         http.Header{"Cache-Control": {"max-age=60"}, "Expires": {"Tue, 01 Oct 2019 13:00:00 GMT"}},
+			expectedExpiry: now.Add(time.Minute),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.True(t, test.expectedExpiry.Equal(keySetExpiry(test.header, now, DefaultKeySetLifetime)))
+		})
+	}
+}
+
+func genCountingTestServer(cacheControl string, delay time.Duration, keys ...jose.JSONWebKey) (*httptest.Server, *uint64, *int32) {
+	var counter uint64
+	var failing int32
+	value, _ := json.Marshal(JWKS{Keys: keys})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&counter, 1)
+		time.Sleep(delay)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		fmt.Fprintln(w, string(value))
+	}))
+	return ts, &counter, &failing
+}
+
+func TestGetKeyConcurrentMissesShareDownload(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	ts, counter, _ := genCountingTestServer("", 100*time.Millisecond, jsonWebKeyRS256.Public())
+	defer ts.Close()
+
+	client := NewJWKClient(JWKClientOptions{URI: ts.URL}, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetKey("keyRS256")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint64(1), atomic.LoadUint64(counter))
+}
+
+// unsafeKeyCacher is a KeyCacher not safe for concurrent use,
+// recording whether two additions ever overlapped.
+type unsafeKeyCacher struct {
+	keys       map[string]jose.JSONWebKey
+	adding     int32
+	overlapped int32
+}
+
+func (c *unsafeKeyCacher) Get(keyID string) (*jose.JSONWebKey, error) {
+	return nil, ErrNoKeyFound
+}
+
+func (c *unsafeKeyCacher) Add(keyID string, webKeys []jose.JSONWebKey) (*jose.JSONWebKey, error) {
+	if atomic.AddInt32(&c.adding, 1) > 1 {
+		atomic.StoreInt32(&c.overlapped, 1)
+	}
+	defer atomic.AddInt32(&c.adding, -1)
+	time.Sleep(time.Millisecond)
+
+	for _, key := range webKeys {
+		if key.KeyID == keyID {
+			c.keys[keyID] = key
+			return &key, nil
+		}
+	}
+	return nil, ErrNoKeyFound
+}
+
+func TestGetKeySerializesCustomCacherAdditions(t *testing.T) {
+	var keys []jose.JSONWebKey
+	for i := 0; i < 10; i++ {
+		key := genRSASSAJWK(jose.RS256, fmt.Sprint("key", i))
+		keys = append(keys, key.Public())
+	}
+	ts, _, _ := genCountingTestServer("max-age=600", 0, keys...)
+	defer ts.Close()
+
+	cacher := &unsafeKeyCacher{keys: map[string]jose.JSONWebKey{}}
+	client := NewJWKClientWithCache(JWKClientOptions{URI: ts.URL}, nil, cacher)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(keyID string) {
+			defer wg.Done()
+			_, err := client.GetKey(keyID)
+			assert.NoError(t, err)
+		}(fmt.Sprint("key", i))
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&cacher.overlapped))
+	assert.Len(t, cacher.keys, 10)
+}
+
+func TestGetKeyServesLastGoodKeySet(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	ts, counter, failing := genCountingTestServer("no-cache", 0, jsonWebKeyRS256.Public())
+	defer ts.Close()
+
+	// Keys expire from the cache right away, forcing a lookup in the key set.
+	client := NewJWKClientWithCache(JWKClientOptions{URI: ts.URL, Retries: -1}, nil, NewMemoryKeyCacher(time.Duration(0), 5))
+
+	_, err := client.GetKey("keyRS256")
+	assert.NoError(t, err)
+
+	atomic.StoreInt32(failing, 1)
+	_, err = client.GetKey("keyRS256")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), atomic.LoadUint64(counter))
+
+	_, err = client.GetKey("unknownKey")
+	assert.Error(t, err)
+}
+
+func TestGetKeyUsesFreshKeySet(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	ts, counter, _ := genCountingTestServer("max-age=600", 0, jsonWebKeyRS256.Public())
+	defer ts.Close()
+
+	client := NewJWKClientWithCache(JWKClientOptions{URI: ts.URL}, nil, NewMemoryKeyCacher(time.Duration(0), 5))
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetKey("keyRS256")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint64(1), atomic.LoadUint64(counter))
+}
+
+func TestJWKClientBackgroundRefresh(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+
+	tests := []struct {
+		name          string
+		cacheControl  string
+		failing       int32
+		expectedWaits []time.Duration
+	}{
+		{
+			name:          "pass - refreshed ahead of expiry",
+			cacheControl:  "max-age=600",
+			expectedWaits: []time.Duration{0, 600*time.Second - DefaultRefreshAhead, 600*time.Second - DefaultRefreshAhead},
+		},
+		{
+			name:          "pass - short lifetime bounded",
+			cacheControl:  "max-age=0",
+			expectedWaits: []time.Duration{0, minRefreshWait, minRefreshWait},
+		},
+		{
+			name:          "fail - endpoint down",
+			failing:       1,
+			expectedWaits: []time.Duration{0, minRefreshWait, minRefreshWait},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts, counter, failing := genCountingTestServer(test.cacheControl, 0, jsonWebKeyRS256.Public())
+			defer ts.Close()
+			atomic.StoreInt32(failing, test.failing)
+
+			// the refresh loop reports each wait and refreshes when told to
+			waits := make(chan time.Duration)
+			refresh := make(chan time.Time)
+			stopped := make(chan struct{})
+			client := NewJWKClient(JWKClientOptions{URI: ts.URL, Clock: newFakeClock(), Retries: -1}, nil)
+			client.newTimer = func(wait time.Duration) (<-chan time.Time, func() bool) {
+				waits <- wait
+				return refresh, func() bool { close(stopped); return true }
+			}
+			// started like with BackgroundRefresh, once the timer is overridden
+			go client.refreshLoop()
+			for i, expectedWait := range test.expectedWaits {
+				assert.Equal(t, expectedWait, <-waits)
+				assert.Equal(t, uint64(i), atomic.LoadUint64(counter))
+				if i < len(test.expectedWaits)-1 {
+					refresh <- time.Time{}
+				}
+			}
+			assert.Equal(t, test.failing == 0, client.currentKeySet() != nil)
+
+			client.Close()
+			<-stopped
+			assert.Equal(t, uint64(len(test.expectedWaits)-1), atomic.LoadUint64(counter), "no refresh should happen after Close")
+		})
+	}
+}
+
+func TestGetKeyDownloadLimits(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+
+	tests := []struct {
+		name              string
+		options           JWKClientOptions
+		cacheControl      string
+		keyIDs            []string
+		expectedErrors    []error
+		expectedDownloads uint64
+	}{
+		{
+			name:              "pass - limits disabled",
+			options:           JWKClientOptions{MinDownloadInterval: -1},
+			keyIDs:            []string{"unknown", "unknown"},
+			expectedErrors:    []error{ErrNoKeyFound, ErrNoKeyFound},
+			expectedDownloads: 2,
+		},
+		{
+			name:              "fail - default download interval",
+			keyIDs:            []string{"unknown", "other"},
+			expectedErrors:    []error{ErrNoKeyFound, ErrKeyLookupSuppressed},
+			expectedDownloads: 1,
+		},
+		{
+			name:              "pass - stale key set downloaded again",
+			cacheControl:      "no-cache",
+			keyIDs:            []string{"unknown", "other"},
+			expectedErrors:    []error{ErrNoKeyFound, ErrNoKeyFound},
+			expectedDownloads: 2,
+		},
+		{
+			name:              "fail - unknown key ID negatively cached",
+			options:           JWKClientOptions{NegativeCacheTTL: time.Hour, MinDownloadInterval: -1},
+			keyIDs:            []string{"unknown", "unknown", "other"},
+			expectedErrors:    []error{ErrNoKeyFound, ErrKeyLookupSuppressed, ErrNoKeyFound},
+			expectedDownloads: 2,
+		},
+		{
+			name:              "fail - negative cache entry expired",
+			options:           JWKClientOptions{NegativeCacheTTL: time.Nanosecond, MinDownloadInterval: -1},
+			keyIDs:            []string{"unknown", "unknown"},
+			expectedErrors:    []error{ErrNoKeyFound, ErrNoKeyFound},
+			expectedDownloads: 2,
+		},
+		{
+			name:              "fail - downloads too close",
+			options:           JWKClientOptions{MinDownloadInterval: time.Hour},
+			keyIDs:            []string{"unknown", "other"},
+			expectedErrors:    []error{ErrNoKeyFound, ErrKeyLookupSuppressed},
+			expectedDownloads: 1,
+		},
+		{
+			name:              "pass - published key served from the key set",
+			options:           JWKClientOptions{MinDownloadInterval: time.Hour},
+			keyIDs:            []string{"unknown", "keyRS256"},
+			expectedErrors:    []error{ErrNoKeyFound, nil},
+			expectedDownloads: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts, counter, _ := genCountingTestServer(test.cacheControl, 0, jsonWebKeyRS256.Public())
+			defer ts.Close()
+
+			test.options.URI = ts.URL
+			client := NewJWKClient(test.options, nil)
+			for i, keyID := range test.keyIDs {
+				_, err := client.GetKey(keyID)
+				assert.Equal(t, test.expectedErrors[i], err)
+			}
+			assert.Equal(t, test.expectedDownloads, atomic.LoadUint64(counter))
+		})
+	}
+}
+
+type contextRecordingKeyCacher struct {
+	KeyCacher
+	contexts []context.Context
+}
+
+func (c *contextRecordingKeyCacher) GetContext(ctx context.Context, keyID string) (*jose.JSONWebKey, error) {
+	c.contexts = append(c.contexts, ctx)
+	return c.Get(keyID)
+}
+
+func (c *contextRecordingKeyCacher) AddContext(ctx context.Context, keyID string, webKeys []jose.JSONWebKey) (*jose.JSONWebKey, error) {
+	c.contexts = append(c.contexts, ctx)
+	return c.Add(keyID, webKeys)
+}
+
+func TestGetKeyContext(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	ts, _, _ := genCountingTestServer("", 0, jsonWebKeyRS256.Public())
+	defer ts.Close()
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	cacher := &contextRecordingKeyCacher{KeyCacher: NewMemoryKeyCacher(MaxKeyAgeNoCheck, 5)}
+	client := NewJWKClientWithCache(JWKClientOptions{URI: ts.URL}, nil, cacher)
+
+	_, err := client.GetKeyContext(ctx, "keyRS256")
+	assert.NoError(t, err)
+	_, err = client.GetKeyContext(ctx, "keyRS256")
+	assert.NoError(t, err)
+
+	// get and add on the first lookup, get on the second one
+	assert.Len(t, cacher.contexts, 3)
+	for _, c := range cacher.contexts {
+		assert.Equal(t, "value", c.Value(ctxKey{}))
+	}
+}
+
+func TestGetKeyContextCanceled(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	ts, counter, _ := genCountingTestServer("", 200*time.Millisecond, jsonWebKeyRS256.Public())
+	defer ts.Close()
+
+	client := NewJWKClient(JWKClientOptions{URI: ts.URL}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		_, err := client.GetKeyContext(ctx, "keyRS256")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+		assert.True(t, time.Since(start) < 150*time.Millisecond, "the lookup should stop with its context")
+	}()
+
+	// a lookup with another context waiting for the canceled download downloads again
+	time.Sleep(5 * time.Millisecond)
+	_, err := client.GetKeyContext(context.Background(), "keyRS256")
+	assert.NoError(t, err)
+	wg.Wait()
+	assert.Equal(t, uint64(2), atomic.LoadUint64(counter))
+}
+
+func TestStaleKeyEviction(t *testing.T) {
+	jsonWebKey1 := genRSASSAJWK(jose.RS256, "key1")
+	jsonWebKey2 := genRSASSAJWK(jose.RS256, "key2")
+
+	tests := []struct {
+		name            string
+		gracePeriod     time.Duration
+		republish       bool
+		expectedServed  bool
+		expectedRemoved []string
+	}{
+		{
+			name:            "fail - removed right away",
+			expectedRemoved: []string{"key1"},
+		},
+		{
+			name:            "fail - removed after the grace period",
+			gracePeriod:     time.Hour,
+			expectedServed:  true,
+			expectedRemoved: []string{"key1"},
+		},
+		{
+			name:           "pass - published again during the grace period",
+			gracePeriod:    time.Hour,
+			republish:      true,
+			expectedServed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var published atomic.Value
+			published.Store([]jose.JSONWebKey{jsonWebKey1.Public(), jsonWebKey2.Public()})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				jwksHandler(published.Load().([]jose.JSONWebKey)...)(w, r)
+			}))
+			defer ts.Close()
+
+			clock := newFakeClock()
+			var removed []string
+			client := NewJWKClient(JWKClientOptions{
+				URI:                 ts.URL,
+				Clock:               clock,
+				StaleKeyGracePeriod: test.gracePeriod,
+				OnKeyRemoved:        func(keyID string) { removed = append(removed, keyID) },
+			}, nil)
+
+			_, err := client.GetKey("key1")
+			assert.NoError(t, err)
+
+			published.Store([]jose.JSONWebKey{jsonWebKey2.Public()})
+			_, err = client.downloadKeys()
+			assert.NoError(t, err)
+
+			_, err = client.GetKey("key1")
+			assert.Equal(t, test.expectedServed, err == nil, "unexpected error: %v", err)
+
+			if test.republish {
+				published.Store([]jose.JSONWebKey{jsonWebKey1.Public(), jsonWebKey2.Public()})
+				_, err = client.downloadKeys()
+				assert.NoError(t, err)
+			}
+			clock.Add(time.Hour)
+
+			_, err = client.GetKey("key1")
+			assert.Equal(t, test.republish, err == nil, "unexpected error: %v", err)
+			_, err = client.GetKey("key2")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedRemoved, removed)
+		})
+	}
+}
+
+func TestStaleKeyRemovedOnCacheHit(t *testing.T) {
+	jsonWebKey1 := genRSASSAJWK(jose.RS256, "key1")
+	jsonWebKey2 := genRSASSAJWK(jose.RS256, "key2")
+
+	tests := []struct {
+		name              string
+		failing           bool
+		hanging           bool
+		expectedServed    bool
+		expectedRemoved   []string
+		expectedDownloads uint64
+	}{
+		{
+			name:              "fail - removed once the key set expired",
+			expectedRemoved:   []string{"key1"},
+			expectedDownloads: 2,
+		},
+		{
+			name:              "pass - served while the JWKS endpoint is down",
+			failing:           true,
+			expectedServed:    true,
+			expectedDownloads: 2,
+		},
+		{
+			name:              "fail - served while the JWKS endpoint hangs, then removed",
+			hanging:           true,
+			expectedRemoved:   []string{"key1"},
+			expectedDownloads: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var published atomic.Value
+			published.Store([]jose.JSONWebKey{jsonWebKey1.Public(), jsonWebKey2.Public()})
+			var downloads uint64
+			var failing int32
+			release := make(chan struct{})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddUint64(&downloads, 1) > 1 && test.hanging {
+					<-release
+				}
+				if atomic.LoadInt32(&failing) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				jwksHandler(published.Load().([]jose.JSONWebKey)...)(w, r)
+			}))
+			defer ts.Close()
+
+			clock := newFakeClock()
+			var removed []string
+			// no background refresh, the keys are cached for good
+			client := NewJWKClient(JWKClientOptions{
+				URI:          ts.URL,
+				Clock:        clock,
+				Retries:      -1,
+				OnKeyRemoved: func(keyID string) { removed = append(removed, keyID) },
+			}, nil)
+
+			_, err := client.GetKey("key1")
+			assert.NoError(t, err)
+
+			published.Store([]jose.JSONWebKey{jsonWebKey2.Public()})
+			if test.failing {
+				atomic.StoreInt32(&failing, 1)
+			}
+			_, err = client.GetKey("key1")
+			assert.NoError(t, err, "the key set has not expired yet")
+
+			clock.Add(DefaultKeySetLifetime)
+			_, err = client.GetKey("key1")
+			assert.NoError(t, err, "cache hits never wait for the download")
+			close(release)
+			client.refreshes.Wait()
+
+			_, err = client.GetKey("key1")
+			assert.Equal(t, test.expectedServed, err == nil, "unexpected error: %v", err)
+			client.refreshes.Wait()
+
+			assert.Equal(t, test.expectedRemoved, removed)
+			assert.Equal(t, test.expectedDownloads, atomic.LoadUint64(&downloads))
+		})
+	}
+}
+
+func TestRemovedKeyNotAddedBack(t *testing.T) {
+	jsonWebKey1 := genRSASSAJWK(jose.RS256, "key1")
+	jsonWebKey2 := genRSASSAJWK(jose.RS256, "key2")
+	var published atomic.Value
+	published.Store([]jose.JSONWebKey{jsonWebKey1.Public(), jsonWebKey2.Public()})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwksHandler(published.Load().([]jose.JSONWebKey)...)(w, r)
+	}))
+	defer ts.Close()
+
+	cacher := newMemoryKeyCacher(MaxKeyAgeNoCheck, MaxCacheSizeNoCheck, systemClock)
+	client := NewJWKClientWithCache(JWKClientOptions{URI: ts.URL}, nil, cacher)
+	_, err := client.downloadKeys()
+	assert.NoError(t, err)
+
+	// key1 is removed while a lookup of key2 started with the previous key set
+	published.Store([]jose.JSONWebKey{jsonWebKey2.Public()})
+	_, err = client.downloadKeys()
+	assert.NoError(t, err)
+	_, err = client.addKey(context.Background(), "key2")
+	assert.NoError(t, err)
+
+	_, err = cacher.Get("key1")
+	assert.Equal(t, ErrNoKeyFound, err)
+	_, err = client.addKey(context.Background(), "key1")
+	assert.Equal(t, ErrNoKeyFound, err)
+}
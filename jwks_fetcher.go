@@ -0,0 +1,272 @@
+package auth0
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnexpectedJWKSStatus is returned when the JWKS endpoint
+	// answers with another status than 200 OK.
+	ErrUnexpectedJWKSStatus = errors.New("unexpected status of the JWKS endpoint")
+	// ErrJWKSTooLarge is returned when the JWKS response
+	// is larger than the MaxResponseSize of the JWKClientOptions.
+	ErrJWKSTooLarge = errors.New("JWKS response is too large")
+	// ErrJWKSRedirect is returned when the JWKS endpoint
+	// redirects to another host, or from HTTPS to HTTP.
+	ErrJWKSRedirect = errors.New("JWKS endpoint redirected to another host")
+	// ErrJWKSCircuitOpen is returned while downloads are suspended
+	// after too many consecutive failures of the JWKS endpoint.
+	ErrJWKSCircuitOpen = errors.New("JWKS endpoint is unavailable, downloads are suspended")
+)
+
+const (
+	// DefaultJWKSConnectTimeout bounds the connection to the JWKS endpoint
+	// when no Client is given in the JWKClientOptions.
+	DefaultJWKSConnectTimeout = 5 * time.Second
+	// DefaultJWKSTimeout bounds each request to the JWKS endpoint
+	// when no Client is given in the JWKClientOptions.
+	DefaultJWKSTimeout = 10 * time.Second
+	// DefaultMaxJWKSSize is the default maximum size of a JWKS response.
+	DefaultMaxJWKSSize = 1 << 20
+	// DefaultJWKSRetries is the default number of retries of a download
+	// failing with a 5xx status or a timeout.
+	DefaultJWKSRetries = 2
+	// DefaultJWKSRetryBackoff is the default wait before the first retry,
+	// doubled at each retry.
+	DefaultJWKSRetryBackoff = 200 * time.Millisecond
+	// DefaultCircuitBreakerThreshold is the default number of consecutive
+	// failed downloads after which downloads are suspended.
+	DefaultCircuitBreakerThreshold = 5
+	// DefaultCircuitBreakerCooldown is the default time downloads are
+	// suspended for before the JWKS endpoint is tried again.
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// maxJWKSRetryBackoff bounds the wait between two retries.
+const maxJWKSRetryBackoff = 5 * time.Second
+
+// maxJWKSRedirects bounds the number of redirects followed.
+const maxJWKSRedirects = 5
+
+// newJWKSHTTPClient returns the client used when the
+// JWKClientOptions do not provide one.
+func newJWKSHTTPClient(options JWKClientOptions) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   options.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = options.ConnectTimeout
+	transport.ResponseHeaderTimeout = options.Timeout
+
+	return &http.Client{
+		Transport:     transport,
+		Timeout:       options.Timeout,
+		CheckRedirect: sameHostRedirect,
+	}
+}
+
+// sameHostRedirect only follows redirects to the host of the
+// original request, without downgrading from HTTPS to HTTP.
+func sameHostRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxJWKSRedirects {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrJWKSRedirect, len(via))
+	}
+	original := via[0].URL
+	if req.URL.Host != original.Host || (original.Scheme == "https" && req.URL.Scheme != "https") {
+		return fmt.Errorf("%w: %s://%s", ErrJWKSRedirect, req.URL.Scheme, req.URL.Host)
+	}
+	return nil
+}
+
+// jwksResponse is a successful response of the JWKS endpoint.
+type jwksResponse struct {
+	header http.Header
+	body   []byte
+	// notModified tells whether the endpoint answered 304 Not Modified
+	// to a conditional request, in which case body is empty.
+	notModified bool
+}
+
+// fetchKeySet requests the JWKS endpoint, retrying with an exponential
+// backoff when it fails with a 5xx status or a timeout. The request is
+// conditional when current has an ETag or a Last-Modified date.
+func (j *JWKClient) fetchKeySet(ctx context.Context, current *keySet) (*jwksResponse, error) {
+	if err := j.breaker.allow(j.options.Clock.Now()); err != nil {
+		return nil, err
+	}
+
+	var resp *jwksResponse
+	var err error
+	for attempt := 0; ; attempt++ {
+		resp, err = j.fetchKeySetOnce(ctx, current)
+		if err == nil || attempt >= j.options.Retries || !retryableFetchError(err) || ctx.Err() != nil {
+			break
+		}
+
+		timer := time.NewTimer(j.retryBackoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// failures due to the context of the caller say nothing of the endpoint
+	j.breaker.record(err != nil && ctx.Err() == nil, ctx.Err() != nil, j.options.Clock.Now())
+	return resp, err
+}
+
+func (j *JWKClient) fetchKeySetOnce(ctx context.Context, current *keySet) (*jwksResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", j.options.URI, nil)
+	if err != nil {
+		return nil, err
+	}
+	conditional := false
+	if current != nil && current.etag != "" {
+		req.Header.Set("If-None-Match", current.etag)
+		conditional = true
+	}
+	if current != nil && current.lastModified != "" {
+		req.Header.Set("If-Modified-Since", current.lastModified)
+		conditional = true
+	}
+
+	resp, err := j.options.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && conditional {
+		return &jwksResponse{header: resp.Header, notModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		// drain a little of the body so that the connection can be reused
+		io.CopyN(ioutil.Discard, resp.Body, 4096)
+		return nil, &jwksStatusError{statusCode: resp.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, j.options.MaxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > j.options.MaxResponseSize {
+		return nil, ErrJWKSTooLarge
+	}
+	return &jwksResponse{header: resp.Header, body: body}, nil
+}
+
+// jwksStatusError wraps ErrUnexpectedJWKSStatus with the status received.
+type jwksStatusError struct {
+	statusCode int
+}
+
+func (e *jwksStatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s", ErrUnexpectedJWKSStatus, e.statusCode, http.StatusText(e.statusCode))
+}
+
+func (e *jwksStatusError) Unwrap() error {
+	return ErrUnexpectedJWKSStatus
+}
+
+// retryableFetchError tells whether err is a 5xx status or a timeout.
+func retryableFetchError(err error) bool {
+	var statusErr *jwksStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryBackoff returns the wait before the retry following attempt:
+// the exponential backoff, half of which is random.
+func (j *JWKClient) retryBackoff(attempt int) time.Duration {
+	backoff := maxJWKSRetryBackoff
+	if attempt < 16 && j.options.RetryBackoff<<uint(attempt) < maxJWKSRetryBackoff {
+		backoff = j.options.RetryBackoff << uint(attempt)
+	}
+	half := backoff / 2
+	if half <= 0 {
+		return backoff
+	}
+	return half + jitter(half)
+}
+
+var (
+	jitterMu     sync.Mutex
+	jitterSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns a random duration in [0, max).
+func jitter(max time.Duration) time.Duration {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterSource.Int63n(int64(max)))
+}
+
+// circuitBreaker suspends the downloads for cooldown after threshold
+// consecutive failures, then lets a single download probe the endpoint.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow(now time.Time) error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return ErrJWKSCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a download allowed by allow.
+// Canceled downloads are not counted.
+func (b *circuitBreaker) record(failed, canceled bool, now time.Time) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	switch {
+	case canceled:
+	case failed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openUntil = now.Add(b.cooldown)
+		}
+	default:
+		b.failures = 0
+	}
+}
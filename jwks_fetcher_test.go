@@ -0,0 +1,322 @@
+package auth0
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// genScriptedTestServer answers the nth request with the nth handler,
+// and the requests after the last handler with the last one.
+func genScriptedTestServer(handlers ...http.HandlerFunc) (*httptest.Server, *uint64) {
+	var counter uint64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddUint64(&counter, 1)) - 1
+		if n >= len(handlers) {
+			n = len(handlers) - 1
+		}
+		handlers[n](w, r)
+	}))
+	return ts, &counter
+}
+
+func jwksHandler(keys ...jose.JSONWebKey) http.HandlerFunc {
+	value, _ := json.Marshal(JWKS{Keys: keys})
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(value)
+	}
+}
+
+func statusHandler(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}
+}
+
+func slowHandler(delay time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		next(w, r)
+	}
+}
+
+func TestFetchKeySet(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	ok := jwksHandler(jsonWebKeyRS256.Public())
+
+	tests := []struct {
+		name              string
+		options           JWKClientOptions
+		handlers          []http.HandlerFunc
+		expectedErr       error
+		expectedDownloads uint64
+	}{
+		{
+			name:              "pass - 5xx retried",
+			handlers:          []http.HandlerFunc{statusHandler(http.StatusBadGateway), statusHandler(http.StatusServiceUnavailable), ok},
+			expectedDownloads: 3,
+		},
+		{
+			name:              "fail - retries exhausted",
+			options:           JWKClientOptions{Retries: 1},
+			handlers:          []http.HandlerFunc{statusHandler(http.StatusInternalServerError)},
+			expectedErr:       ErrUnexpectedJWKSStatus,
+			expectedDownloads: 2,
+		},
+		{
+			name:              "fail - retries disabled",
+			options:           JWKClientOptions{Retries: -1},
+			handlers:          []http.HandlerFunc{statusHandler(http.StatusInternalServerError), ok},
+			expectedErr:       ErrUnexpectedJWKSStatus,
+			expectedDownloads: 1,
+		},
+		{
+			name:              "fail - 4xx not retried",
+			handlers:          []http.HandlerFunc{statusHandler(http.StatusNotFound), ok},
+			expectedErr:       ErrUnexpectedJWKSStatus,
+			expectedDownloads: 1,
+		},
+		{
+			name:              "pass - timeout retried",
+			options:           JWKClientOptions{Timeout: 50 * time.Millisecond},
+			handlers:          []http.HandlerFunc{slowHandler(200*time.Millisecond, ok), ok},
+			expectedDownloads: 2,
+		},
+		{
+			name:              "fail - response too large",
+			options:           JWKClientOptions{MaxResponseSize: 64},
+			handlers:          []http.HandlerFunc{ok},
+			expectedErr:       ErrJWKSTooLarge,
+			expectedDownloads: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts, counter := genScriptedTestServer(test.handlers...)
+			defer ts.Close()
+
+			test.options.URI = ts.URL
+			test.options.RetryBackoff = time.Millisecond
+			client := NewJWKClient(test.options, nil)
+
+			keys, err := client.downloadKeys()
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), "unexpected error: %v", err)
+			} else {
+				assert.NoError(t, err)
+				assert.Len(t, keys, 1)
+			}
+			assert.Equal(t, test.expectedDownloads, atomic.LoadUint64(counter))
+		})
+	}
+}
+
+func TestSameHostRedirect(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	other := httptest.NewServer(jwksHandler(jsonWebKeyRS256.Public()))
+	defer other.Close()
+
+	tests := []struct {
+		name        string
+		location    string
+		expectedErr error
+	}{
+		{
+			name:     "pass - same host",
+			location: "/jwks.json",
+		},
+		{
+			name:        "fail - other host",
+			location:    other.URL + "/jwks.json",
+			expectedErr: ErrJWKSRedirect,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/jwks.json") {
+					jwksHandler(jsonWebKeyRS256.Public())(w, r)
+					return
+				}
+				http.Redirect(w, r, test.location, http.StatusFound)
+			}))
+			defer ts.Close()
+
+			client := NewJWKClient(JWKClientOptions{URI: ts.URL}, nil)
+			_, err := client.downloadKeys()
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), "unexpected error: %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestJWKSCircuitBreaker(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	var failing int32 = 1
+	ts, counter := genScriptedTestServer(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		jwksHandler(jsonWebKeyRS256.Public())(w, r)
+	})
+	defer ts.Close()
+
+	clock := newFakeClock()
+	client := NewJWKClient(JWKClientOptions{
+		URI:                     ts.URL,
+		Clock:                   clock,
+		Retries:                 -1,
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  time.Minute,
+	}, nil)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.downloadKeys()
+		assert.True(t, errors.Is(err, ErrUnexpectedJWKSStatus), "unexpected error: %v", err)
+	}
+	_, err := client.downloadKeys()
+	assert.Equal(t, ErrJWKSCircuitOpen, err)
+	assert.Equal(t, uint64(2), atomic.LoadUint64(counter))
+
+	// the probe after the cooldown fails and opens the circuit again
+	clock.Add(time.Minute)
+	_, err = client.downloadKeys()
+	assert.True(t, errors.Is(err, ErrUnexpectedJWKSStatus), "unexpected error: %v", err)
+	_, err = client.downloadKeys()
+	assert.Equal(t, ErrJWKSCircuitOpen, err)
+	assert.Equal(t, uint64(3), atomic.LoadUint64(counter))
+
+	// the probe succeeds and closes the circuit
+	atomic.StoreInt32(&failing, 0)
+	clock.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		_, err = client.downloadKeys()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint64(5), atomic.LoadUint64(counter))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	client := NewJWKClient(JWKClientOptions{RetryBackoff: 100 * time.Millisecond}, nil)
+
+	tests := []struct {
+		attempt     int
+		expectedMax time.Duration
+	}{
+		{attempt: 0, expectedMax: 100 * time.Millisecond},
+		{attempt: 1, expectedMax: 200 * time.Millisecond},
+		{attempt: 3, expectedMax: 800 * time.Millisecond},
+		{attempt: 10, expectedMax: maxJWKSRetryBackoff},
+		{attempt: 100, expectedMax: maxJWKSRetryBackoff},
+	}
+
+	for _, test := range tests {
+		backoff := client.retryBackoff(test.attempt)
+		assert.True(t, backoff >= test.expectedMax/2 && backoff <= test.expectedMax,
+			"backoff of attempt %d out of bounds: %v", test.attempt, backoff)
+	}
+}
+
+func TestConditionalKeySetDownload(t *testing.T) {
+	jsonWebKeyRS256 := genRSASSAJWK(jose.RS256, "keyRS256")
+	lastModified := "Tue, 01 Oct 2019 10:00:00 GMT"
+
+	tests := []struct {
+		name                string
+		validators          http.Header
+		expectedIfNoneMatch string
+		expectedIfModSince  string
+		expectedNotModified bool
+	}{
+		{
+			name:                "pass - ETag",
+			validators:          http.Header{"Etag": {`"v1"`}},
+			expectedIfNoneMatch: `"v1"`,
+			expectedNotModified: true,
+		},
+		{
+			name:                "pass - Last-Modified",
+			validators:          http.Header{"Last-Modified": {lastModified}},
+			expectedIfModSince:  lastModified,
+			expectedNotModified: true,
+		},
+		{
+			name:                "pass - ETag and Last-Modified",
+			validators:          http.Header{"Etag": {`"v1"`}, "Last-Modified": {lastModified}},
+			expectedIfNoneMatch: `"v1"`,
+			expectedIfModSince:  lastModified,
+			expectedNotModified: true,
+		},
+		{
+			name: "pass - no validators",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []http.Header
+			ok := jwksHandler(jsonWebKeyRS256.Public())
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Header)
+				w.Header().Set("Cache-Control", "max-age=60")
+				if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				for key, values := range test.validators {
+					w.Header()[key] = values
+				}
+				ok(w, r)
+			}))
+			defer ts.Close()
+
+			clock := newFakeClock()
+			client := NewJWKClient(JWKClientOptions{URI: ts.URL, Clock: clock}, nil)
+
+			_, err := client.downloadKeys()
+			assert.NoError(t, err)
+			first := client.currentKeySet()
+
+			clock.Add(time.Minute)
+			keys, err := client.downloadKeys()
+			assert.NoError(t, err)
+			assert.Len(t, keys, 1)
+
+			assert.Len(t, requests, 2)
+			assert.Equal(t, test.expectedIfNoneMatch, requests[1].Get("If-None-Match"))
+			assert.Equal(t, test.expectedIfModSince, requests[1].Get("If-Modified-Since"))
+
+			second := client.currentKeySet()
+			assert.Equal(t, clock.Now().Add(time.Minute), second.expiresAt)
+			if test.expectedNotModified {
+				assert.Equal(t, first.keys, second.keys)
+				assert.Equal(t, first.etag, second.etag)
+				assert.Equal(t, first.lastModified, second.lastModified)
+			}
+		})
+	}
+}
+
+func TestUnconditionalNotModified(t *testing.T) {
+	ts, _ := genScriptedTestServer(statusHandler(http.StatusNotModified))
+	defer ts.Close()
+
+	client := NewJWKClient(JWKClientOptions{URI: ts.URL}, nil)
+	_, err := client.downloadKeys()
+	assert.True(t, errors.Is(err, ErrUnexpectedJWKSStatus), "unexpected error: %v", err)
+}
@@ -1,7 +1,10 @@
 package auth0
 
 import (
+	"container/list"
+	"context"
 	"errors"
+	"sync"
 	"time"
 
 	jose "gopkg.in/square/go-jose.v2"
@@ -17,15 +20,58 @@ var (
 	MaxCacheSizeNoCheck = -1
 )
 
+// KeyCacher caches the keys retrieved by the JWKClient.
+// The JWKClient serializes the calls to Add, and to Remove for a
+// KeyRemover, while Get may be called concurrently with them.
 type KeyCacher interface {
 	Get(keyID string) (*jose.JSONWebKey, error)
 	Add(keyID string, webKeys []jose.JSONWebKey) (*jose.JSONWebKey, error)
 }
 
+// ContextKeyCacher is a KeyCacher honouring the context of the lookups,
+// such as a cache shared over the network. The JWKClient uses these
+// methods when its KeyCacher implements them.
+type ContextKeyCacher interface {
+	KeyCacher
+	GetContext(ctx context.Context, keyID string) (*jose.JSONWebKey, error)
+	AddContext(ctx context.Context, keyID string, webKeys []jose.JSONWebKey) (*jose.JSONWebKey, error)
+}
+
+// KeyRemover is implemented by the key cachers able to forget a key,
+// so that the JWKClient stops serving the keys no longer published
+// in the JWKS.
+type KeyRemover interface {
+	Remove(keyID string)
+}
+
+// getCachedKey gets the key from the cacher with ctx
+// when the cacher is a ContextKeyCacher.
+func getCachedKey(ctx context.Context, cacher KeyCacher, keyID string) (*jose.JSONWebKey, error) {
+	if c, ok := cacher.(ContextKeyCacher); ok {
+		return c.GetContext(ctx, keyID)
+	}
+	return cacher.Get(keyID)
+}
+
+// addCachedKey adds the key to the cacher with ctx
+// when the cacher is a ContextKeyCacher.
+func addCachedKey(ctx context.Context, cacher KeyCacher, keyID string, webKeys []jose.JSONWebKey) (*jose.JSONWebKey, error) {
+	if c, ok := cacher.(ContextKeyCacher); ok {
+		return c.AddContext(ctx, keyID, webKeys)
+	}
+	return cacher.Add(keyID, webKeys)
+}
+
+// memoryKeyCacher is a KeyCacher safe for concurrent use, evicting the
+// least recently used key when full. mu guards entries and order, the
+// most recently used key being at the front of order.
 type memoryKeyCacher struct {
-	entries      map[string]keyCacherEntry
+	mu           sync.RWMutex
+	entries      map[string]*list.Element
+	order        *list.List
 	maxKeyAge    time.Duration
 	maxCacheSize int
+	clock        Clock
 }
 
 type keyCacherEntry struct {
@@ -36,54 +82,91 @@ type keyCacherEntry struct {
 // NewMemoryKeyCacher creates a new Keycacher interface with option
 // to set max age of cached keys and max size of the cache.
 func NewMemoryKeyCacher(maxKeyAge time.Duration, maxCacheSize int) KeyCacher {
-	return &memoryKeyCacher{
-		entries:      map[string]keyCacherEntry{},
-		maxKeyAge:    maxKeyAge,
-		maxCacheSize: maxCacheSize,
-	}
+	return NewMemoryKeyCacherWithClock(maxKeyAge, maxCacheSize, systemClock)
+}
+
+// NewMemoryKeyCacherWithClock creates a new Keycacher interface like
+// NewMemoryKeyCacher, measuring the age of the keys with the provided clock.
+func NewMemoryKeyCacherWithClock(maxKeyAge time.Duration, maxCacheSize int, clock Clock) KeyCacher {
+	return newMemoryKeyCacher(maxKeyAge, maxCacheSize, clock)
 }
 
 func newMemoryPersistentKeyCacher() KeyCacher {
+	return newMemoryKeyCacher(MaxKeyAgeNoCheck, MaxCacheSizeNoCheck, systemClock)
+}
+
+func newMemoryKeyCacher(maxKeyAge time.Duration, maxCacheSize int, clock Clock) *memoryKeyCacher {
 	return &memoryKeyCacher{
-		entries:      map[string]keyCacherEntry{},
-		maxKeyAge:    MaxKeyAgeNoCheck,
-		maxCacheSize: MaxCacheSizeNoCheck,
+		entries:      map[string]*list.Element{},
+		order:        list.New(),
+		maxKeyAge:    maxKeyAge,
+		maxCacheSize: maxCacheSize,
+		clock:        clock,
 	}
 }
 
-// Get obtains a key from the cache, and checks if the key is expired
+// Get obtains a key from the cache, and checks if the key is expired.
+// Bounded caches take the write lock to keep the order of the keys
+// up to date, while the lookups of unbounded ones are shared.
 func (mkc *memoryKeyCacher) Get(keyID string) (*jose.JSONWebKey, error) {
-	searchKey, ok := mkc.entries[keyID]
-	if ok {
-		if mkc.maxKeyAge == MaxKeyAgeNoCheck || !mkc.keyIsExpired(keyID) {
-			return &searchKey.JSONWebKey, nil
+	if mkc.maxCacheSize == MaxCacheSizeNoCheck {
+		mkc.mu.RLock()
+		element, ok := mkc.entries[keyID]
+		var entry keyCacherEntry
+		if ok {
+			entry = *element.Value.(*keyCacherEntry)
+		}
+		mkc.mu.RUnlock()
+
+		if !ok {
+			return nil, ErrNoKeyFound
 		}
+		if mkc.keyIsExpired(&entry) {
+			mkc.mu.Lock()
+			// the key may have been added again meanwhile
+			if current, ok := mkc.entries[keyID]; ok && mkc.keyIsExpired(current.Value.(*keyCacherEntry)) {
+				mkc.remove(current)
+			}
+			mkc.mu.Unlock()
+			return nil, ErrKeyExpired
+		}
+		return &entry.JSONWebKey, nil
+	}
+
+	mkc.mu.Lock()
+	defer mkc.mu.Unlock()
+
+	element, ok := mkc.entries[keyID]
+	if !ok {
+		return nil, ErrNoKeyFound
+	}
+	entry := *element.Value.(*keyCacherEntry)
+	if mkc.keyIsExpired(&entry) {
+		mkc.remove(element)
 		return nil, ErrKeyExpired
 	}
-	return nil, ErrNoKeyFound
+	mkc.order.MoveToFront(element)
+	return &entry.JSONWebKey, nil
 }
 
 // Add adds a key into the cache and handles overflow
 func (mkc *memoryKeyCacher) Add(keyID string, downloadedKeys []jose.JSONWebKey) (*jose.JSONWebKey, error) {
 	var addingKey jose.JSONWebKey
 
+	mkc.mu.Lock()
+	defer mkc.mu.Unlock()
+
 	for _, key := range downloadedKeys {
 		if key.KeyID == keyID {
 			addingKey = key
 		}
-		if mkc.maxCacheSize == -1 {
-			mkc.entries[key.KeyID] = keyCacherEntry{
-				addedAt:    time.Now(),
-				JSONWebKey: key,
-			}
+		if mkc.maxCacheSize == MaxCacheSizeNoCheck {
+			mkc.set(key)
 		}
 	}
 	if addingKey.Key != nil {
-		if mkc.maxCacheSize != -1 {
-			mkc.entries[addingKey.KeyID] = keyCacherEntry{
-				addedAt:    time.Now(),
-				JSONWebKey: addingKey,
-			}
+		if mkc.maxCacheSize != MaxCacheSizeNoCheck {
+			mkc.set(addingKey)
 			mkc.handleOverflow()
 		}
 		return &addingKey, nil
@@ -91,26 +174,50 @@ func (mkc *memoryKeyCacher) Add(keyID string, downloadedKeys []jose.JSONWebKey)
 	return nil, ErrNoKeyFound
 }
 
-// keyIsExpired deletes the key from cache if it is expired
-func (mkc *memoryKeyCacher) keyIsExpired(keyID string) bool {
-	if time.Now().After(mkc.entries[keyID].addedAt.Add(mkc.maxKeyAge)) {
-		delete(mkc.entries, keyID)
-		return true
+// Remove deletes a key from the cache.
+func (mkc *memoryKeyCacher) Remove(keyID string) {
+	mkc.mu.Lock()
+	defer mkc.mu.Unlock()
+
+	if element, ok := mkc.entries[keyID]; ok {
+		mkc.remove(element)
+	}
+}
+
+// set stores the key as the most recently used one.
+// mkc.mu must be held.
+func (mkc *memoryKeyCacher) set(key jose.JSONWebKey) {
+	entry := &keyCacherEntry{
+		addedAt:    mkc.clock.Now(),
+		JSONWebKey: key,
 	}
-	return false
+	if element, ok := mkc.entries[key.KeyID]; ok {
+		element.Value = entry
+		mkc.order.MoveToFront(element)
+		return
+	}
+	mkc.entries[key.KeyID] = mkc.order.PushFront(entry)
+}
+
+// remove deletes the key of element from the cache.
+// mkc.mu must be held.
+func (mkc *memoryKeyCacher) remove(element *list.Element) {
+	mkc.order.Remove(element)
+	delete(mkc.entries, element.Value.(*keyCacherEntry).KeyID)
 }
 
-// handleOverflow deletes the oldest key from the cache if overflowed
+// keyIsExpired tells whether the entry is older than the max key age
+func (mkc *memoryKeyCacher) keyIsExpired(entry *keyCacherEntry) bool {
+	if mkc.maxKeyAge == MaxKeyAgeNoCheck {
+		return false
+	}
+	return mkc.clock.Now().After(entry.addedAt.Add(mkc.maxKeyAge))
+}
+
+// handleOverflow deletes the least recently used keys from the cache
+// if overflowed. mkc.mu must be held.
 func (mkc *memoryKeyCacher) handleOverflow() {
-	if mkc.maxCacheSize < len(mkc.entries) {
-		var oldestEntryKeyID string
-		var latestAddedTime = time.Now()
-		for entryKeyID, entry := range mkc.entries {
-			if entry.addedAt.Before(latestAddedTime) {
-				latestAddedTime = entry.addedAt
-				oldestEntryKeyID = entryKeyID
-			}
-		}
-		delete(mkc.entries, oldestEntryKeyID)
+	for mkc.maxCacheSize != MaxCacheSizeNoCheck && mkc.order.Len() > mkc.maxCacheSize {
+		mkc.remove(mkc.order.Back())
 	}
 }
@@ -3,6 +3,7 @@ package auth0
 import (
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -19,120 +20,76 @@ func TestGet(t *testing.T) {
 		expectedErrorMsg string
 	}{
 		{
-			name: "pass - persistent cacher",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    MaxKeyAgeNoCheck,
-				maxCacheSize: MaxCacheSizeNoCheck,
-			},
+			name:             "pass - persistent cacher",
+			mkc:              newMemoryKeyCacher(MaxKeyAgeNoCheck, MaxCacheSizeNoCheck, systemClock),
 			key:              "key1",
 			expectedErrorMsg: "",
 		},
 		{
-			name: "fail - invalid key",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    MaxKeyAgeNoCheck,
-				maxCacheSize: MaxCacheSizeNoCheck,
-			},
+			name:             "fail - invalid key",
+			mkc:              newMemoryKeyCacher(MaxKeyAgeNoCheck, MaxCacheSizeNoCheck, systemClock),
 			key:              "invalid key",
 			expectedErrorMsg: "no Keys has been found",
 		},
 		{
-			name: "fail - persistent cacher get immediately expired key",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(0),
-				maxCacheSize: MaxCacheSizeNoCheck,
-			},
+			name:             "fail - persistent cacher get immediately expired key",
+			mkc:              newMemoryKeyCacher(time.Duration(0), MaxCacheSizeNoCheck, systemClock),
 			key:              "key1",
 			expectedErrorMsg: "key exists but is expired",
 		},
 		{
-			name: "pass - persistent cacher get not expired key",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(10) * time.Second,
-				maxCacheSize: MaxCacheSizeNoCheck,
-			},
+			name:             "pass - persistent cacher get not expired key",
+			mkc:              newMemoryKeyCacher(time.Duration(10)*time.Second, MaxCacheSizeNoCheck, systemClock),
 			key:              "key1",
 			expectedErrorMsg: "",
 		},
 		{
-			name: "fail - no cacher with -1 maxKeyAge",
-			mkc: &memoryKeyCacher{
-				entries:      nil,
-				maxKeyAge:    MaxKeyAgeNoCheck,
-				maxCacheSize: 0,
-			},
+			name:             "fail - no cacher with -1 maxKeyAge",
+			mkc:              newMemoryKeyCacher(MaxKeyAgeNoCheck, 0, systemClock),
 			key:              "key1",
 			expectedErrorMsg: "no Keys has been found",
 		},
 		{
-			name: "fail - no cacher",
-			mkc: &memoryKeyCacher{
-				entries:      nil,
-				maxKeyAge:    time.Duration(0),
-				maxCacheSize: 0,
-			},
+			name:             "fail - no cacher",
+			mkc:              newMemoryKeyCacher(time.Duration(0), 0, systemClock),
 			key:              "key1",
 			expectedErrorMsg: "no Keys has been found",
 		},
 		{
-			name: "fail - no cacher with 10sec max age",
-			mkc: &memoryKeyCacher{
-				entries:      nil,
-				maxKeyAge:    time.Duration(10) * time.Second,
-				maxCacheSize: 0,
-			},
+			name:             "fail - no cacher with 10sec max age",
+			mkc:              newMemoryKeyCacher(time.Duration(10)*time.Second, 0, systemClock),
 			key:              "key1",
 			expectedErrorMsg: "no Keys has been found",
 		},
 		{
-			name: "pass - custom cacher with -1 max age",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    MaxKeyAgeNoCheck,
-				maxCacheSize: 1,
-			},
+			name:             "pass - custom cacher with -1 max age",
+			mkc:              newMemoryKeyCacher(MaxKeyAgeNoCheck, 1, systemClock),
 			key:              "key1",
 			expectedErrorMsg: "",
 		},
 		{
-			name: "fail - custom cacher get immediately expired key",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(0),
-				maxCacheSize: 1,
-			},
+			name:             "fail - custom cacher get immediately expired key",
+			mkc:              newMemoryKeyCacher(time.Duration(0), 1, systemClock),
 			key:              "key1",
 			expectedErrorMsg: "key exists but is expired",
 		},
 		{
-			name: "pass - custom cacher not expired",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(100) * time.Second,
-				maxCacheSize: 1,
-			},
+			name:             "pass - custom cacher not expired",
+			mkc:              newMemoryKeyCacher(time.Duration(100)*time.Second, 1, systemClock),
 			key:              "key1",
 			expectedErrorMsg: "",
 		},
 		{
-			name: "fail - custom cacher with expired key",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(-100) * time.Second, // setting max age negavtive time duration is equivalent to expired keys
-				maxCacheSize: 1,
-			},
+			name:             "fail - custom cacher with expired key",
+			mkc:              newMemoryKeyCacher(time.Duration(-100)*time.Second, 1, systemClock), // setting max age negavtive time duration is equivalent to expired keys
 			key:              "key1",
 			expectedErrorMsg: "key exists but is expired",
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			if test.mkc.entries != nil {
-				test.mkc.entries["key1"] = keyCacherEntry{time.Now(), jose.JSONWebKey{KeyID: "test1"}}
+			if _, err := test.mkc.Add("key1", []jose.JSONWebKey{{Key: []byte("secret"), KeyID: "key1"}}); err != nil {
+				t.Fatalf("Adding key should not have failed with error, but got: %v", err)
 			}
 
 			_, err := test.mkc.Get(test.key)
@@ -168,154 +125,102 @@ func TestAdd(t *testing.T) {
 		expectedErrorMsg string
 	}{
 		{
-			name: "pass - persistent cacher",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    MaxKeyAgeNoCheck,
-				maxCacheSize: MaxCacheSizeNoCheck,
-			},
+			name:             "pass - persistent cacher",
+			mkc:              newMemoryKeyCacher(MaxKeyAgeNoCheck, MaxCacheSizeNoCheck, systemClock),
 			addingKey:        "test1",
 			gettingKey:       "test1",
 			expectedFoundKey: true,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "fail - invalid key",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    MaxKeyAgeNoCheck,
-				maxCacheSize: MaxCacheSizeNoCheck,
-			},
+			name:             "fail - invalid key",
+			mkc:              newMemoryKeyCacher(MaxKeyAgeNoCheck, MaxCacheSizeNoCheck, systemClock),
 			addingKey:        "invalid key",
 			gettingKey:       "invalid key",
 			expectedFoundKey: false,
 			expectedErrorMsg: "no Keys has been found",
 		},
 		{
-			name: "pass - add key for persistent cacher",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(0),
-				maxCacheSize: MaxCacheSizeNoCheck,
-			},
+			name:             "pass - add key for persistent cacher",
+			mkc:              newMemoryKeyCacher(time.Duration(0), MaxCacheSizeNoCheck, systemClock),
 			addingKey:        "test1",
 			gettingKey:       "test1",
 			expectedFoundKey: true,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "pass - add key for persistent cacher",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(10) * time.Second,
-				maxCacheSize: MaxCacheSizeNoCheck,
-			},
+			name:             "pass - add key for persistent cacher",
+			mkc:              newMemoryKeyCacher(time.Duration(10)*time.Second, MaxCacheSizeNoCheck, systemClock),
 			addingKey:        "test1",
 			gettingKey:       "test1",
 			expectedFoundKey: true,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "fail - no cacher with -1 max age",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    MaxKeyAgeNoCheck,
-				maxCacheSize: 0,
-			},
+			name:             "fail - no cacher with -1 max age",
+			mkc:              newMemoryKeyCacher(MaxKeyAgeNoCheck, 0, systemClock),
 			addingKey:        "test1",
 			gettingKey:       "test1",
 			expectedFoundKey: false,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "fail - no cacher",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(0),
-				maxCacheSize: 0,
-			},
+			name:             "fail - no cacher",
+			mkc:              newMemoryKeyCacher(time.Duration(0), 0, systemClock),
 			addingKey:        "test1",
 			gettingKey:       "test1",
 			expectedFoundKey: false,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "fail - no cacher with 10sec max age",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(10) * time.Second,
-				maxCacheSize: 0,
-			},
+			name:             "fail - no cacher with 10sec max age",
+			mkc:              newMemoryKeyCacher(time.Duration(10)*time.Second, 0, systemClock),
 			addingKey:        "test1",
 			gettingKey:       "test1",
 			expectedFoundKey: false,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "pass - custom cacher with -1 max age",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    MaxKeyAgeNoCheck,
-				maxCacheSize: 1,
-			},
+			name:             "pass - custom cacher with -1 max age",
+			mkc:              newMemoryKeyCacher(MaxKeyAgeNoCheck, 1, systemClock),
 			addingKey:        "test1",
 			gettingKey:       "test1",
 			expectedFoundKey: true,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "pass - custom cacher with 0 max age",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(0),
-				maxCacheSize: 1,
-			},
+			name:             "pass - custom cacher with 0 max age",
+			mkc:              newMemoryKeyCacher(time.Duration(0), 1, systemClock),
 			addingKey:        "test1",
 			gettingKey:       "test1",
 			expectedFoundKey: true,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "pass - custom cacher get latest added key",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(100) * time.Second,
-				maxCacheSize: 1,
-			},
+			name:             "pass - custom cacher get latest added key",
+			mkc:              newMemoryKeyCacher(time.Duration(100)*time.Second, 1, systemClock),
 			gettingKey:       "test3",
 			expectedFoundKey: true,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "fail - custom cacher add invalid key",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(100) * time.Second,
-				maxCacheSize: 1,
-			},
+			name:             "fail - custom cacher add invalid key",
+			mkc:              newMemoryKeyCacher(time.Duration(100)*time.Second, 1, systemClock),
 			addingKey:        "invalid key",
 			gettingKey:       "test1",
 			expectedFoundKey: false,
 			expectedErrorMsg: "no Keys has been found",
 		},
 		{
-			name: "fail - custom cacher get key not in cache",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(100) * time.Second,
-				maxCacheSize: 1,
-			},
+			name:             "fail - custom cacher get key not in cache",
+			mkc:              newMemoryKeyCacher(time.Duration(100)*time.Second, 1, systemClock),
 			gettingKey:       "test1",
 			expectedFoundKey: false,
 			expectedErrorMsg: "",
 		},
 		{
-			name: "pass - custom cacher with capacity 3",
-			mkc: &memoryKeyCacher{
-				entries:      make(map[string]keyCacherEntry),
-				maxKeyAge:    time.Duration(100) * time.Second,
-				maxCacheSize: 3,
-			},
+			name:             "pass - custom cacher with capacity 3",
+			mkc:              newMemoryKeyCacher(time.Duration(100)*time.Second, 3, systemClock),
 			gettingKey:       "test2",
 			expectedFoundKey: true,
 			expectedErrorMsg: "",
@@ -352,37 +257,38 @@ func TestAdd(t *testing.T) {
 func TestKeyIsExpired(t *testing.T) {
 	tests := []struct {
 		name         string
-		mkc          *memoryKeyCacher
-		sleepingTime int
+		maxKeyAge    time.Duration
+		elapsed      time.Duration
 		expectedBool bool
 	}{
 		{
-			name: "true - key is expired",
-			mkc: &memoryKeyCacher{
-				entries:      map[string]keyCacherEntry{},
-				maxKeyAge:    time.Duration(1) * time.Second,
-				maxCacheSize: 1,
-			},
+			name:         "true - key is expired",
+			maxKeyAge:    time.Duration(1) * time.Second,
+			elapsed:      time.Duration(10) * time.Second,
 			expectedBool: true,
 		},
 		{
-			name: "false - key not expired",
-			mkc: &memoryKeyCacher{
-				entries:      map[string]keyCacherEntry{},
-				maxKeyAge:    time.Duration(10) * time.Second,
-				maxCacheSize: 1,
-			},
+			name:         "false - key not expired",
+			maxKeyAge:    time.Duration(10) * time.Second,
+			elapsed:      time.Duration(1) * time.Second,
+			expectedBool: false,
+		},
+		{
+			name:         "false - key at max age",
+			maxKeyAge:    time.Duration(10) * time.Second,
+			elapsed:      time.Duration(10) * time.Second,
 			expectedBool: false,
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			if test.expectedBool {
-				test.mkc.entries["test1"] = keyCacherEntry{time.Now().Add(time.Duration(-10) * time.Second), jose.JSONWebKey{KeyID: "test1"}}
-			} else {
-				test.mkc.entries["test1"] = keyCacherEntry{time.Now(), jose.JSONWebKey{KeyID: "test1"}}
+			clock := newFakeClock()
+			mkc := NewMemoryKeyCacherWithClock(test.maxKeyAge, 1, clock).(*memoryKeyCacher)
+			if _, err := mkc.Add("test1", []jose.JSONWebKey{{Key: []byte("secret"), KeyID: "test1"}}); err != nil {
+				t.Fatalf("Adding key should not have failed with error, but got: %v", err)
 			}
-			if test.mkc.keyIsExpired("test1") != test.expectedBool {
+			clock.Add(test.elapsed)
+			if _, err := mkc.Get("test1"); (err == ErrKeyExpired) != test.expectedBool {
 				t.Errorf("Should have been " + strconv.FormatBool(test.expectedBool) + " but got different")
 			}
 		})
@@ -398,32 +304,103 @@ func TestHandleOverflow(t *testing.T) {
 		expectedLength int
 	}{
 		{
-			name: "true - overflowed and delete 1 key",
-			mkc: &memoryKeyCacher{
-				entries:      map[string]keyCacherEntry{},
-				maxKeyAge:    time.Duration(2) * time.Second,
-				maxCacheSize: 1,
-			},
+			name:           "true - overflowed and delete 1 key",
+			mkc:            newMemoryKeyCacher(time.Duration(2)*time.Second, 1, systemClock),
 			expectedLength: 1,
 		},
 		{
-			name: "false - no overflow",
-			mkc: &memoryKeyCacher{
-				entries:      map[string]keyCacherEntry{},
-				maxKeyAge:    time.Duration(2) * time.Second,
-				maxCacheSize: 2,
-			},
+			name:           "false - no overflow",
+			mkc:            newMemoryKeyCacher(time.Duration(2)*time.Second, 2, systemClock),
 			expectedLength: 2,
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			test.mkc.entries["first"] = keyCacherEntry{JSONWebKey: downloadedKeys[0]}
-			test.mkc.entries["second"] = keyCacherEntry{JSONWebKey: downloadedKeys[1]}
+			test.mkc.set(downloadedKeys[0])
+			test.mkc.set(downloadedKeys[1])
 			test.mkc.handleOverflow()
-			if len(test.mkc.entries) != test.expectedLength {
+			if len(test.mkc.entries) != test.expectedLength || test.mkc.order.Len() != test.expectedLength {
 				t.Errorf("Should have been " + strconv.Itoa(test.expectedLength) + "but got different")
 			}
 		})
 	}
 }
+
+func TestLeastRecentlyUsedEviction(t *testing.T) {
+	keys := []jose.JSONWebKey{
+		{Key: []byte("secret1"), KeyID: "test1"},
+		{Key: []byte("secret2"), KeyID: "test2"},
+		{Key: []byte("secret3"), KeyID: "test3"},
+	}
+	mkc := NewMemoryKeyCacher(MaxKeyAgeNoCheck, 2)
+
+	for _, ID := range []string{"test1", "test2"} {
+		_, err := mkc.Add(ID, keys)
+		assert.Nil(t, err)
+	}
+	// test1 becomes the most recently used key, test2 is evicted
+	_, err := mkc.Get("test1")
+	assert.Nil(t, err)
+	_, err = mkc.Add("test3", keys)
+	assert.Nil(t, err)
+
+	_, err = mkc.Get("test1")
+	assert.Nil(t, err)
+	_, err = mkc.Get("test2")
+	assert.Equal(t, ErrNoKeyFound, err)
+	_, err = mkc.Get("test3")
+	assert.Nil(t, err)
+}
+
+func TestRemove(t *testing.T) {
+	keys := []jose.JSONWebKey{
+		{Key: []byte("secret1"), KeyID: "test1"},
+		{Key: []byte("secret2"), KeyID: "test2"},
+	}
+	mkc := newMemoryPersistentKeyCacher()
+	_, err := mkc.Add("test1", keys)
+	assert.Nil(t, err)
+
+	mkc.(KeyRemover).Remove("test1")
+	mkc.(KeyRemover).Remove("unknown")
+
+	_, err = mkc.Get("test1")
+	assert.Equal(t, ErrNoKeyFound, err)
+	_, err = mkc.Get("test2")
+	assert.Nil(t, err)
+}
+
+func TestMemoryKeyCacherConcurrency(t *testing.T) {
+	keys := make([]jose.JSONWebKey, 50)
+	for i := range keys {
+		keys[i] = jose.JSONWebKey{Key: []byte("secret"), KeyID: "test" + strconv.Itoa(i)}
+	}
+
+	for _, mkc := range []KeyCacher{
+		NewMemoryKeyCacher(time.Millisecond, 10),
+		NewMemoryKeyCacher(MaxKeyAgeNoCheck, 10),
+		newMemoryPersistentKeyCacher(),
+	} {
+		var wg sync.WaitGroup
+		for g := 0; g < 32; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					ID := keys[(g+i)%len(keys)].KeyID
+					if _, err := mkc.Get(ID); err != nil {
+						if _, err = mkc.Add(ID, keys); err != nil {
+							t.Errorf("Adding key should not have failed with error, but got: %v", err)
+						}
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		if m, ok := mkc.(*memoryKeyCacher); ok && m.maxCacheSize != MaxCacheSizeNoCheck {
+			assert.True(t, m.order.Len() <= m.maxCacheSize)
+			assert.Equal(t, m.order.Len(), len(m.entries))
+		}
+	}
+}
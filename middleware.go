@@ -0,0 +1,146 @@
+package auth0
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Authorizer decides whether the claims of a validated
+// token grant access to the request.
+type Authorizer interface {
+	Authorize(r *http.Request, claims map[string]interface{}) error
+}
+
+// AuthorizerFunc simple wrapper to authorize
+// requests with functions.
+type AuthorizerFunc func(r *http.Request, claims map[string]interface{}) error
+
+// Authorize implements the Authorizer interface.
+func (f AuthorizerFunc) Authorize(r *http.Request, claims map[string]interface{}) error {
+	return f(r, claims)
+}
+
+// AuthorizationError is handed to the ErrorHandler when
+// an Authorizer denies access to a request holding a valid token.
+type AuthorizationError struct {
+	Err error
+}
+
+func (e *AuthorizationError) Error() string {
+	return "access denied: " + e.Err.Error()
+}
+
+// Unwrap returns the error returned by the Authorizer.
+func (e *AuthorizationError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler writes the response of a request
+// rejected by the middleware.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// DefaultErrorHandler answers with a 403 when an Authorizer denied access
+// and with a 401 otherwise, setting the WWW-Authenticate header as
+// described in RFC 6750. The Reason of a ValidationError is used
+// as the error description, and the scopes of an InsufficientScopeError
+// as the required scope. Invalid DPoP proofs are answered
+// with the invalid_dpop_proof error of RFC 9449.
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	var authErr *AuthorizationError
+	switch {
+	case errors.As(err, &authErr):
+		challenge := `Bearer error="insufficient_scope"`
+		var scopeErr *InsufficientScopeError
+		if errors.As(err, &scopeErr) {
+			challenge += `, scope="` + strings.Join(scopeErr.Required, " ") + `"`
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case errors.Is(err, &ValidationError{Reason: ReasonInvalidDPoPProof}):
+		w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	case errors.Is(err, ErrTokenNotFound):
+		// No error code when the request lacks any authentication information.
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	default:
+		challenge := `Bearer error="invalid_token"`
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			challenge += `, error_description="` + string(validationErr.Reason) + `"`
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+}
+
+// MiddlewareOption configures the middleware
+// returned by JWTValidator.Middleware.
+type MiddlewareOption func(*middleware)
+
+// WithErrorHandler replaces the DefaultErrorHandler.
+func WithErrorHandler(handler ErrorHandler) MiddlewareOption {
+	return func(m *middleware) {
+		m.errorHandler = handler
+	}
+}
+
+// WithCredentialsOptional lets requests without any token reach
+// the next handler. Requests carrying an invalid token are still rejected.
+func WithCredentialsOptional() MiddlewareOption {
+	return func(m *middleware) {
+		m.credentialsOptional = true
+	}
+}
+
+// WithAuthorizers adds authorizers that all have to grant
+// access once the token has been validated.
+func WithAuthorizers(authorizers ...Authorizer) MiddlewareOption {
+	return func(m *middleware) {
+		m.authorizers = append(m.authorizers, authorizers...)
+	}
+}
+
+type middleware struct {
+	validator           *JWTValidator
+	errorHandler        ErrorHandler
+	credentialsOptional bool
+	authorizers         []Authorizer
+}
+
+// Middleware returns a net/http middleware validating the token of
+// every request. The validated token and its claims are stored in the
+// request context and can be retrieved with FromContext.
+func (v *JWTValidator) Middleware(opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	m := &middleware{
+		validator:    v,
+		errorHandler: DefaultErrorHandler,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			validated, err := m.validator.validateRequest(r, m.validator.config.leeway)
+			if errors.Is(err, ErrTokenNotFound) && m.credentialsOptional {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				m.errorHandler(w, r, err)
+				return
+			}
+
+			for _, authorizer := range m.authorizers {
+				if err := authorizer.Authorize(r, validated.CustomClaims); err != nil {
+					m.errorHandler(w, r, &AuthorizationError{Err: err})
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), validated)))
+		})
+	}
+}
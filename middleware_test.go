@@ -0,0 +1,99 @@
+package auth0
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestMiddleware(t *testing.T) {
+	configuration := NewConfiguration(defaultSecretProvider, defaultAudience, defaultIssuer, jose.HS256)
+	validToken := getTestToken(defaultAudience, defaultIssuer, time.Now().Add(24*time.Hour), jose.HS256, defaultSecret)
+	expiredToken := getTestToken(defaultAudience, defaultIssuer, time.Now().Add(-24*time.Hour), jose.HS256, defaultSecret)
+	denyAll := AuthorizerFunc(func(r *http.Request, claims map[string]interface{}) error {
+		return errors.New("denied")
+	})
+
+	tests := []struct {
+		name                    string
+		opts                    []MiddlewareOption
+		token                   string
+		expectedStatus          int
+		expectedWWWAuthenticate string
+		expectedClaims          bool
+	}{
+		{
+			name:           "pass - valid token",
+			token:          validToken,
+			expectedStatus: http.StatusOK,
+			expectedClaims: true,
+		},
+		{
+			name:                    "fail - no token",
+			expectedStatus:          http.StatusUnauthorized,
+			expectedWWWAuthenticate: "Bearer",
+		},
+		{
+			name:                    "fail - expired token",
+			token:                   expiredToken,
+			expectedStatus:          http.StatusUnauthorized,
+			expectedWWWAuthenticate: `Bearer error="invalid_token", error_description="expired"`,
+		},
+		{
+			name:           "pass - no token with credentials optional",
+			opts:           []MiddlewareOption{WithCredentialsOptional()},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:                    "fail - expired token with credentials optional",
+			opts:                    []MiddlewareOption{WithCredentialsOptional()},
+			token:                   expiredToken,
+			expectedStatus:          http.StatusUnauthorized,
+			expectedWWWAuthenticate: `Bearer error="invalid_token", error_description="expired"`,
+		},
+		{
+			name:                    "fail - denied by authorizer",
+			opts:                    []MiddlewareOption{WithAuthorizers(denyAll)},
+			token:                   validToken,
+			expectedStatus:          http.StatusForbidden,
+			expectedWWWAuthenticate: `Bearer error="insufficient_scope"`,
+		},
+		{
+			name: "fail - custom error handler",
+			opts: []MiddlewareOption{WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+				w.WriteHeader(http.StatusTeapot)
+			})},
+			token:          expiredToken,
+			expectedStatus: http.StatusTeapot,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := NewValidator(configuration, nil)
+
+			var claimsFound bool
+			handler := validator.Middleware(test.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, tokenFound := TokenFromContext(r.Context())
+				claims, ok := ClaimsFromContext(r.Context())
+				claimsFound = tokenFound && ok && claims["iss"] == defaultIssuer
+			}))
+
+			req := httptest.NewRequest("GET", "http://localhost", nil)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.expectedStatus, rec.Code)
+			assert.Equal(t, test.expectedWWWAuthenticate, rec.Header().Get("WWW-Authenticate"))
+			assert.Equal(t, test.expectedClaims, claimsFound)
+		})
+	}
+}
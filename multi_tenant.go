@@ -0,0 +1,149 @@
+package auth0
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+var (
+	// ErrUnknownIssuer is returned when no tenant is registered for the issuer of the token.
+	ErrUnknownIssuer = errors.New("no tenant registered for the issuer of the token")
+	// ErrNoIssuer is returned when registering a tenant whose configuration has no issuer.
+	ErrNoIssuer = errors.New("the configuration of a tenant must have an issuer")
+)
+
+// MultiTenantValidator validates tokens issued by several
+// tenants, each with its own configuration and secret provider.
+// Tenants can be added and removed while validating requests.
+type MultiTenantValidator struct {
+	extractor RequestTokenExtractor
+	// mu guards tenants and decryptionKeyProvider.
+	mu                    sync.RWMutex
+	tenants               map[string]*JWTValidator
+	decryptionKeyProvider DecryptionKeyProvider
+}
+
+// NewMultiTenantValidator creates a new
+// validator without any tenant.
+func NewMultiTenantValidator(extractor RequestTokenExtractor) *MultiTenantValidator {
+	if extractor == nil {
+		extractor = RequestTokenExtractorFunc(FromHeader)
+	}
+	return &MultiTenantValidator{
+		extractor: extractor,
+		tenants:   map[string]*JWTValidator{},
+	}
+}
+
+// AddTenant registers the configuration for the tokens of its issuer,
+// replacing any configuration previously registered for it.
+func (m *MultiTenantValidator) AddTenant(config Configuration) error {
+	issuer := config.expectedClaims.Issuer
+	if issuer == "" {
+		return ErrNoIssuer
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tenants[issuer] = NewValidator(config, m.extractor)
+	return nil
+}
+
+// SetDecryptionKeyProvider sets the provider of the keys decrypting nested
+// tokens. Encrypted tokens are decrypted before their issuer is known, so
+// the decryption key providers of the tenant configurations are not used.
+func (m *MultiTenantValidator) SetDecryptionKeyProvider(provider DecryptionKeyProvider) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decryptionKeyProvider = provider
+}
+
+// RemoveTenant unregisters the configuration of the issuer.
+func (m *MultiTenantValidator) RemoveTenant(issuer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tenants, issuer)
+}
+
+// ValidateRequest validates the token within the
+// http request with the configuration of its issuer.
+func (m *MultiTenantValidator) ValidateRequest(r *http.Request) (*jwt.JSONWebToken, error) {
+	validated, err := m.ValidateRequestClaims(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return validated.Token, nil
+}
+
+// ValidateRequestClaims validates the token within the http request with
+// the configuration of its issuer, and unmarshalls its claims into custom.
+func (m *MultiTenantValidator) ValidateRequestClaims(r *http.Request, custom interface{}) (*ValidatedToken, error) {
+	token, err := m.extract(r)
+	if err != nil {
+		return nil, err
+	}
+	validator, err := m.tenant(token)
+	if err != nil {
+		return nil, err
+	}
+	// the request goes through the same checks as with the tenant validator,
+	// such as the proof of possession of the key the token is bound to
+	return validator.validateRequestToken(r, token, validator.config.leeway, custom)
+}
+
+func (m *MultiTenantValidator) extract(r *http.Request) (*jwt.JSONWebToken, error) {
+	m.mu.RLock()
+	provider := m.decryptionKeyProvider
+	m.mu.RUnlock()
+
+	if provider != nil {
+		r = r.WithContext(withDecryptionKeyProvider(r.Context(), provider))
+	}
+	token, err := m.extractor.Extract(r)
+	if err != nil {
+		return nil, newValidationError(extractionReason(err), err)
+	}
+	return token, nil
+}
+
+// ValidateToken validates the token with the configuration of its issuer.
+func (m *MultiTenantValidator) ValidateToken(token *jwt.JSONWebToken) error {
+	_, err := m.ValidateTokenClaims(token, nil)
+	return err
+}
+
+// ValidateTokenClaims validates the token with the configuration
+// of its issuer, and unmarshalls its claims into custom.
+// Tokens of unknown issuers are rejected before their secret is retrieved.
+func (m *MultiTenantValidator) ValidateTokenClaims(token *jwt.JSONWebToken, custom interface{}) (*ValidatedToken, error) {
+	return m.validateTokenClaims(context.Background(), token, custom)
+}
+
+func (m *MultiTenantValidator) validateTokenClaims(ctx context.Context, token *jwt.JSONWebToken, custom interface{}) (*ValidatedToken, error) {
+	validator, err := m.tenant(token)
+	if err != nil {
+		return nil, err
+	}
+	return validator.validateToken(ctx, token, validator.config.leeway, custom)
+}
+
+// tenant returns the validator of the tenant that issued the token,
+// peeking at its issuer claim without verifying the token.
+func (m *MultiTenantValidator) tenant(token *jwt.JSONWebToken) (*JWTValidator, error) {
+	unverified := jwt.Claims{}
+	if err := token.UnsafeClaimsWithoutVerification(&unverified); err != nil {
+		return nil, newValidationError(ReasonMalformed, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	validator, ok := m.tenants[unverified.Issuer]
+	if !ok {
+		return nil, newValidationError(ReasonWrongIssuer, ErrUnknownIssuer)
+	}
+	return validator, nil
+}
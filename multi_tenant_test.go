@@ -0,0 +1,294 @@
+package auth0
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestMultiTenantValidator(t *testing.T) {
+	var secretRequested bool
+	countingProvider := func(key interface{}) SecretProvider {
+		return SecretProviderFunc(func(_ *jwt.JSONWebToken) (interface{}, error) {
+			secretRequested = true
+			return key, nil
+		})
+	}
+	secretA := []byte("secret-a")
+	secretB := genRSASSAJWK(jose.RS256, "")
+
+	validator := NewMultiTenantValidator(nil)
+	assert.Nil(t, validator.AddTenant(NewConfiguration(countingProvider(secretA), defaultAudience, "https://a.example.com/", jose.HS256)))
+	assert.Nil(t, validator.AddTenant(NewConfiguration(countingProvider(secretB.Public()), defaultAudience, "https://b.example.com/", jose.RS256)))
+	assert.True(t, errors.Is(validator.AddTenant(NewConfiguration(defaultSecretProvider, defaultAudience, "", jose.HS256)), ErrNoIssuer))
+
+	expiry := time.Now().Add(24 * time.Hour)
+	tests := []struct {
+		name                string
+		token               string
+		expectedError       error
+		expectSecretRequest bool
+	}{
+		{
+			name:                "pass - first tenant",
+			token:               getTestToken(defaultAudience, "https://a.example.com/", expiry, jose.HS256, secretA),
+			expectSecretRequest: true,
+		},
+		{
+			name:                "pass - second tenant",
+			token:               getTestToken(defaultAudience, "https://b.example.com/", expiry, jose.RS256, secretB),
+			expectSecretRequest: true,
+		},
+		{
+			name:          "fail - unknown issuer",
+			token:         getTestToken(defaultAudience, "https://c.example.com/", expiry, jose.HS256, secretA),
+			expectedError: ErrUnknownIssuer,
+		},
+		{
+			name:                "fail - signed with the key of another tenant",
+			token:               getTestToken(defaultAudience, "https://a.example.com/", expiry, jose.HS256, []byte("secret-b")),
+			expectedError:       &ValidationError{Reason: ReasonBadSignature},
+			expectSecretRequest: true,
+		},
+		{
+			name:          "fail - no token",
+			expectedError: ErrTokenNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secretRequested = false
+			req := httptest.NewRequest("GET", "http://localhost", nil)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			_, err := validator.ValidateRequest(req)
+			if test.expectedError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, test.expectedError), "unexpected error: %v", err)
+			}
+			assert.Equal(t, test.expectSecretRequest, secretRequested)
+		})
+	}
+}
+
+func TestMultiTenantValidatorRemoveTenant(t *testing.T) {
+	validator := NewMultiTenantValidator(nil)
+	assert.Nil(t, validator.AddTenant(NewConfiguration(defaultSecretProvider, defaultAudience, defaultIssuer, jose.HS256)))
+
+	token, err := jwt.ParseSigned(getTestToken(defaultAudience, defaultIssuer, time.Now().Add(24*time.Hour), jose.HS256, defaultSecret))
+	assert.Nil(t, err)
+	assert.Nil(t, validator.ValidateToken(token))
+
+	validator.RemoveTenant(defaultIssuer)
+	err = validator.ValidateToken(token)
+	assert.True(t, errors.Is(err, ErrUnknownIssuer))
+	assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonWrongIssuer}))
+}
+
+func TestMultiTenantValidatorClaims(t *testing.T) {
+	validator := NewMultiTenantValidator(nil)
+	assert.Nil(t, validator.AddTenant(NewConfigurationWithOptions(defaultSecretProvider,
+		WithAudience(defaultAudience...),
+		WithIssuer(defaultIssuer),
+		WithClaimsNamespace("https://example.com/"),
+	)))
+
+	token, err := jwt.ParseSigned(getTestTokenWithClaims(map[string]interface{}{
+		"iss":                      defaultIssuer,
+		"aud":                      defaultAudience,
+		"exp":                      time.Now().Add(time.Hour).Unix(),
+		"https://example.com/role": "admin",
+	}, jose.HS256, defaultSecret))
+	assert.Nil(t, err)
+
+	custom := struct {
+		Role string `json:"role"`
+	}{}
+	validated, err := validator.ValidateTokenClaims(token, &custom)
+	assert.Nil(t, err)
+	assert.Equal(t, defaultIssuer, validated.Claims.Issuer)
+	assert.Equal(t, "admin", custom.Role)
+}
+
+func TestMultiTenantValidatorEncryptedToken(t *testing.T) {
+	encryptionKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	claims := jwt.Claims{Issuer: defaultIssuer, Audience: defaultAudience, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	encryptedToken := getTestEncryptedToken(claims, jose.HS256, defaultSecret, &encryptionKey.PublicKey)
+
+	validator := NewMultiTenantValidator(nil)
+	// the decryption key provider of a tenant cannot be used before its issuer is known
+	assert.Nil(t, validator.AddTenant(NewConfigurationWithOptions(defaultSecretProvider,
+		WithAudience(defaultAudience...),
+		WithIssuer(defaultIssuer),
+		WithAlgorithms(jose.HS256),
+		WithDecryptionKeyProvider(NewDecryptionKeyProvider(encryptionKey)),
+	)))
+
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.Header.Set("Authorization", "Bearer "+encryptedToken)
+	_, err := validator.ValidateRequest(req)
+	assert.True(t, errors.Is(err, ErrNoDecryptionKeyProvider), "unexpected error: %v", err)
+	assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonDecryptionFailed}))
+
+	validator.SetDecryptionKeyProvider(NewDecryptionKeyProvider(encryptionKey))
+	_, err = validator.ValidateRequest(req)
+	assert.Nil(t, err)
+}
+
+func TestMultiTenantValidatorDPoP(t *testing.T) {
+	proofKey := genECDSAJWK(jose.ES256, "")
+	token := getTestTokenWithClaims(map[string]interface{}{
+		"iss": defaultIssuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"cnf": map[string]string{"jkt": getTestThumbprint(proofKey)},
+	}, jose.HS256, defaultSecret)
+	hash := sha256.Sum256([]byte(token))
+	proofs := 0
+
+	tests := []struct {
+		name          string
+		authorization string
+		withProof     bool
+		expectedError error
+	}{
+		{
+			name:          "pass - DPoP proof",
+			authorization: "DPoP " + token,
+			withProof:     true,
+		},
+		{
+			name:          "fail - bound token sent as a bearer token",
+			authorization: "Bearer " + token,
+			expectedError: ErrDPoPBindingMismatch,
+		},
+		{
+			name:          "fail - no DPoP proof",
+			authorization: "DPoP " + token,
+			expectedError: ErrMissingDPoPProof,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := NewMultiTenantValidator(nil)
+			assert.Nil(t, validator.AddTenant(NewConfigurationWithOptions(defaultSecretProvider,
+				WithIssuer(defaultIssuer),
+				WithDPoP(DPoPOptions{Required: true}),
+			)))
+
+			for _, validate := range []func(r *http.Request) error{
+				func(r *http.Request) error { _, err := validator.ValidateRequest(r); return err },
+				func(r *http.Request) error {
+					_, err := validator.ValidateRequestClaims(r, &map[string]interface{}{})
+					return err
+				},
+			} {
+				req := httptest.NewRequest("GET", "http://api.example.com/internal", nil)
+				req.Header.Set("Authorization", test.authorization)
+				if test.withProof {
+					// every proof can only be used once
+					proofs++
+					req.Header.Set("DPoP", getTestDPoPProof(proofKey, "dpop+jwt", map[string]interface{}{
+						"jti": fmt.Sprint("proof-", proofs),
+						"htm": "GET",
+						"htu": "http://api.example.com/internal",
+						"iat": time.Now().Unix(),
+						"ath": base64.RawURLEncoding.EncodeToString(hash[:]),
+					}))
+				}
+
+				err := validate(req)
+				if test.expectedError == nil {
+					assert.Nil(t, err)
+				} else {
+					assert.True(t, errors.Is(err, test.expectedError), "unexpected error: %v", err)
+					assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonInvalidDPoPProof}))
+				}
+			}
+		})
+	}
+}
+
+func TestMultiTenantValidatorCertificateBinding(t *testing.T) {
+	certificate, thumbprint := genTestClientCertificate(t)
+	_, otherThumbprint := genTestClientCertificate(t)
+	peer, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.Nil(t, err)
+	token := func(thumbprint string) string {
+		return getTestTokenWithClaims(map[string]interface{}{
+			"iss": defaultIssuer,
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"cnf": map[string]string{"x5t#S256": thumbprint},
+		}, jose.HS256, defaultSecret)
+	}
+
+	tests := []struct {
+		name              string
+		token             string
+		clientCertificate bool
+		expectedError     error
+	}{
+		{
+			name:              "pass - bound to the client certificate",
+			token:             token(thumbprint),
+			clientCertificate: true,
+		},
+		{
+			name:              "fail - bound to another certificate",
+			token:             token(otherThumbprint),
+			clientCertificate: true,
+			expectedError:     ErrCertificateMismatch,
+		},
+		{
+			name:          "fail - no client certificate",
+			token:         token(thumbprint),
+			expectedError: ErrNoClientCertificate,
+		},
+	}
+
+	validator := NewMultiTenantValidator(nil)
+	assert.Nil(t, validator.AddTenant(NewConfigurationWithOptions(defaultSecretProvider,
+		WithIssuer(defaultIssuer),
+		WithCertificateBinding(),
+	)))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, validate := range []func(r *http.Request) error{
+				func(r *http.Request) error { _, err := validator.ValidateRequest(r); return err },
+				func(r *http.Request) error {
+					_, err := validator.ValidateRequestClaims(r, &map[string]interface{}{})
+					return err
+				},
+			} {
+				req := httptest.NewRequest("GET", "https://api.example.com/internal", nil)
+				req.Header.Set("Authorization", "Bearer "+test.token)
+				if test.clientCertificate {
+					req.TLS.PeerCertificates = []*x509.Certificate{peer}
+				}
+
+				err := validate(req)
+				if test.expectedError == nil {
+					assert.Nil(t, err)
+				} else {
+					assert.True(t, errors.Is(err, test.expectedError), "unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package auth0
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTokenReplayed is returned when a token
+	// whose ID was already used is validated again.
+	ErrTokenReplayed = errors.New("token already used (jti)")
+	// ErrMissingTokenID is returned when replay protection
+	// is enabled and the token has no jti claim.
+	ErrMissingTokenID = errors.New("token has no ID (jti)")
+	// ErrReplayCacheFull is returned when the memory replay cache holds
+	// as many unexpired token IDs as it can, so that no token can be
+	// accepted without forgetting one that could then be replayed.
+	ErrReplayCacheFull = errors.New("replay cache is full")
+)
+
+// ReplayCache records the IDs of the tokens already used,
+// so that each token can only be validated once.
+// Implementations backed by a shared store allow
+// to reject tokens replayed against other instances.
+type ReplayCache interface {
+	// Use records the token ID until expiry and returns
+	// ErrTokenReplayed if it was already recorded.
+	Use(id string, expiry time.Time) error
+}
+
+// ReplayCacheFunc simple wrapper to record
+// token IDs with functions.
+type ReplayCacheFunc func(id string, expiry time.Time) error
+
+// Use implements the ReplayCache interface.
+func (f ReplayCacheFunc) Use(id string, expiry time.Time) error {
+	return f(id, expiry)
+}
+
+type memoryReplayCache struct {
+	mu      sync.Mutex
+	maxSize int
+	clock   Clock
+	ids     map[string]*replayEntry
+	expiry  replayHeap
+}
+
+type replayEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+// NewMemoryReplayCache creates a ReplayCache holding at most maxSize token IDs,
+// or any number of them with MaxCacheSizeNoCheck. Expired IDs are forgotten,
+// and when maxSize IDs are still unexpired, Use fails with ErrReplayCacheFull,
+// so maxSize should exceed the number of tokens used within their lifetime.
+func NewMemoryReplayCache(maxSize int) ReplayCache {
+	return NewMemoryReplayCacheWithClock(maxSize, systemClock)
+}
+
+// NewMemoryReplayCacheWithClock creates a memory ReplayCache
+// whose expiries are compared to the provided clock.
+func NewMemoryReplayCacheWithClock(maxSize int, clock Clock) ReplayCache {
+	return &memoryReplayCache{
+		maxSize: maxSize,
+		clock:   clock,
+		ids:     map[string]*replayEntry{},
+	}
+}
+
+// Use implements the ReplayCache interface.
+func (c *memoryReplayCache) Use(id string, expiry time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	for len(c.expiry) > 0 && !c.expiry[0].expiresAt.After(now) {
+		delete(c.ids, heap.Pop(&c.expiry).(*replayEntry).id)
+	}
+
+	if _, ok := c.ids[id]; ok {
+		return ErrTokenReplayed
+	}
+	if c.maxSize > 0 && len(c.expiry) >= c.maxSize {
+		return ErrReplayCacheFull
+	}
+
+	entry := &replayEntry{id: id, expiresAt: expiry}
+	heap.Push(&c.expiry, entry)
+	c.ids[id] = entry
+	return nil
+}
+
+// replayHeap orders the entries of the memory
+// replay cache by expiry, implementing heap.Interface.
+type replayHeap []*replayEntry
+
+func (h replayHeap) Len() int {
+	return len(h)
+}
+
+func (h replayHeap) Less(i, j int) bool {
+	return h[i].expiresAt.Before(h[j].expiresAt)
+}
+
+func (h replayHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *replayHeap) Push(x interface{}) {
+	*h = append(*h, x.(*replayEntry))
+}
+
+func (h *replayHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,171 @@
+package auth0
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestMemoryReplayCache(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewMemoryReplayCacheWithClock(2, clock)
+
+	assert.Nil(t, cache.Use("a", clock.Now().Add(time.Hour)))
+	assert.Equal(t, ErrTokenReplayed, cache.Use("a", clock.Now().Add(time.Hour)))
+	assert.Nil(t, cache.Use("b", clock.Now().Add(2*time.Hour)))
+
+	// "a" expires first and is forgotten to make room for "c"
+	clock.Add(time.Hour)
+	assert.Nil(t, cache.Use("c", clock.Now().Add(3*time.Hour)))
+	assert.Equal(t, ErrTokenReplayed, cache.Use("c", clock.Now().Add(3*time.Hour)))
+
+	// expired IDs are forgotten
+	clock.Add(4 * time.Hour)
+	assert.Nil(t, cache.Use("c", clock.Now().Add(time.Hour)))
+}
+
+func TestMemoryReplayCacheFull(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewMemoryReplayCacheWithClock(2, clock)
+
+	assert.Nil(t, cache.Use("a", clock.Now().Add(time.Hour)))
+	assert.Nil(t, cache.Use("b", clock.Now().Add(2*time.Hour)))
+
+	// no unexpired ID is forgotten, so that none can be replayed
+	assert.Equal(t, ErrReplayCacheFull, cache.Use("c", clock.Now().Add(time.Hour)))
+	assert.Equal(t, ErrTokenReplayed, cache.Use("a", clock.Now().Add(time.Hour)))
+	assert.Equal(t, ErrTokenReplayed, cache.Use("b", clock.Now().Add(2*time.Hour)))
+
+	clock.Add(time.Hour)
+	assert.Nil(t, cache.Use("c", clock.Now().Add(time.Hour)))
+	assert.Equal(t, ErrTokenReplayed, cache.Use("b", clock.Now().Add(time.Hour)))
+}
+
+func TestMemoryReplayCacheUnbounded(t *testing.T) {
+	cache := NewMemoryReplayCache(MaxCacheSizeNoCheck)
+	expiry := time.Now().Add(time.Hour)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, cache.Use(fmt.Sprint(i), expiry))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, ErrTokenReplayed, cache.Use(fmt.Sprint(i), expiry))
+	}
+}
+
+func TestValidateWithReplayCache(t *testing.T) {
+	configuration := NewConfigurationWithOptions(defaultSecretProvider,
+		WithIssuer(defaultIssuer),
+		WithReplayCache(NewMemoryReplayCache(100)),
+	)
+	validator := NewValidator(configuration, nil)
+	claims := jwt.Claims{Issuer: defaultIssuer, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	tests := []struct {
+		name           string
+		id             string
+		expectedError  error
+		expectedReason Reason
+	}{
+		{
+			name: "pass - first use",
+			id:   "payment-1",
+		},
+		{
+			name:           "fail - replayed",
+			id:             "payment-1",
+			expectedError:  ErrTokenReplayed,
+			expectedReason: ReasonReplayed,
+		},
+		{
+			name: "pass - other token",
+			id:   "payment-2",
+		},
+		{
+			name:           "fail - no jti",
+			expectedError:  ErrMissingTokenID,
+			expectedReason: ReasonInvalidClaims,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims.ID = test.id
+			token, err := jwt.ParseSigned(getTestTokenWithClaims(claims, jose.HS256, defaultSecret))
+			assert.Nil(t, err)
+
+			err = validator.ValidateToken(token)
+			if test.expectedError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, test.expectedError), "expected %v, got %v", test.expectedError, err)
+				assert.True(t, errors.Is(err, &ValidationError{Reason: test.expectedReason}))
+			}
+		})
+	}
+}
+
+func TestReplayCacheSkippedForInvalidTokens(t *testing.T) {
+	var used bool
+	configuration := NewConfigurationWithOptions(defaultSecretProvider,
+		WithIssuer(defaultIssuer),
+		WithReplayCache(ReplayCacheFunc(func(id string, expiry time.Time) error {
+			used = true
+			return nil
+		})),
+	)
+	token, err := jwt.ParseSigned(getTestTokenWithClaims(jwt.Claims{
+		ID:     "payment-1",
+		Issuer: "other",
+		Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, jose.HS256, defaultSecret))
+	assert.Nil(t, err)
+
+	err = NewValidator(configuration, nil).ValidateToken(token)
+	assert.True(t, errors.Is(err, jwt.ErrInvalidIssuer))
+	assert.False(t, used, "the ID of an invalid token should not be recorded")
+}
+
+func TestReplayCacheSkippedForUnboundRequests(t *testing.T) {
+	proofKey := genECDSAJWK(jose.ES256, "")
+	token := getTestTokenWithClaims(map[string]interface{}{
+		"jti": "payment-1",
+		"iss": defaultIssuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"cnf": map[string]string{"jkt": getTestThumbprint(proofKey)},
+	}, jose.HS256, defaultSecret)
+	hash := sha256.Sum256([]byte(token))
+
+	configuration := NewConfigurationWithOptions(defaultSecretProvider,
+		WithIssuer(defaultIssuer),
+		WithReplayCache(NewMemoryReplayCache(100)),
+		WithDPoP(DPoPOptions{}),
+	)
+	validator := NewValidator(configuration, nil)
+
+	// a stolen token sent without proof does not use up its ID
+	req := httptest.NewRequest("GET", "http://api.example.com/payments", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, err := validator.ValidateRequest(req)
+	assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonInvalidDPoPProof}), "unexpected error: %v", err)
+
+	req = httptest.NewRequest("GET", "http://api.example.com/payments", nil)
+	req.Header.Set("Authorization", "DPoP "+token)
+	req.Header.Set("DPoP", getTestDPoPProof(proofKey, "dpop+jwt", map[string]interface{}{
+		"jti": "proof-1",
+		"htm": "GET",
+		"htu": "http://api.example.com/payments",
+		"iat": time.Now().Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(hash[:]),
+	}))
+	_, err = validator.ValidateRequest(req)
+	assert.Nil(t, err)
+}
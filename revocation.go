@@ -0,0 +1,243 @@
+package auth0
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTokenRevoked is returned when a Revoker denies a token.
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// Revoker tells whether a token, valid otherwise, has been revoked.
+type Revoker interface {
+	IsRevoked(token *ValidatedToken) (bool, error)
+}
+
+// RevokerFunc simple wrapper to check
+// revocations with functions.
+type RevokerFunc func(token *ValidatedToken) (bool, error)
+
+// IsRevoked implements the Revoker interface.
+func (f RevokerFunc) IsRevoked(token *ValidatedToken) (bool, error) {
+	return f(token)
+}
+
+// RevocationList lists the revoked tokens, by ID, by subject, by client
+// (client_id or azp claim), or by subject for the tokens issued before a time.
+type RevocationList struct {
+	IDs                  []string             `json:"jti,omitempty"`
+	Subjects             []string             `json:"sub,omitempty"`
+	Clients              []string             `json:"client_id,omitempty"`
+	SubjectsIssuedBefore map[string]time.Time `json:"sub_issued_before,omitempty"`
+}
+
+type revocationIndex struct {
+	ids          map[string]struct{}
+	subjects     map[string]struct{}
+	clients      map[string]struct{}
+	issuedBefore map[string]time.Time
+}
+
+func newRevocationIndex(list RevocationList) *revocationIndex {
+	index := &revocationIndex{
+		ids:          map[string]struct{}{},
+		subjects:     map[string]struct{}{},
+		clients:      map[string]struct{}{},
+		issuedBefore: map[string]time.Time{},
+	}
+	for _, id := range list.IDs {
+		index.ids[id] = struct{}{}
+	}
+	for _, subject := range list.Subjects {
+		index.subjects[subject] = struct{}{}
+	}
+	for _, client := range list.Clients {
+		index.clients[client] = struct{}{}
+	}
+	for subject, t := range list.SubjectsIssuedBefore {
+		index.issuedBefore[subject] = t
+	}
+	return index
+}
+
+func (i *revocationIndex) revoked(token *ValidatedToken) bool {
+	if _, ok := i.ids[token.Claims.ID]; ok && token.Claims.ID != "" {
+		return true
+	}
+	if _, ok := i.subjects[token.Claims.Subject]; ok && token.Claims.Subject != "" {
+		return true
+	}
+	for _, name := range []string{"client_id", "azp"} {
+		if client, _ := token.CustomClaims[name].(string); client != "" {
+			if _, ok := i.clients[client]; ok {
+				return true
+			}
+		}
+	}
+	if t, ok := i.issuedBefore[token.Claims.Subject]; ok && token.Claims.Subject != "" {
+		// tokens without iat cannot prove they were issued afterwards
+		return token.Claims.IssuedAt == 0 || token.Claims.IssuedAt.Time().Before(t)
+	}
+	return false
+}
+
+// MemoryRevoker is a Revoker holding its revocations in memory.
+// It is safe for concurrent use.
+type MemoryRevoker struct {
+	mu    sync.RWMutex
+	index *revocationIndex
+}
+
+// NewMemoryRevoker creates a MemoryRevoker revoking the tokens of list.
+func NewMemoryRevoker(list RevocationList) *MemoryRevoker {
+	return &MemoryRevoker{index: newRevocationIndex(list)}
+}
+
+// RevokeID revokes the token with the jti claim id.
+func (r *MemoryRevoker) RevokeID(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.index.ids[id] = struct{}{}
+}
+
+// RevokeSubject revokes every token of the subject.
+func (r *MemoryRevoker) RevokeSubject(subject string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.index.subjects[subject] = struct{}{}
+}
+
+// RevokeClient revokes every token whose client_id or azp claim is client.
+func (r *MemoryRevoker) RevokeClient(client string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.index.clients[client] = struct{}{}
+}
+
+// RevokeIssuedBefore revokes the tokens of the subject issued before t.
+func (r *MemoryRevoker) RevokeIssuedBefore(subject string, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.index.issuedBefore[subject] = t
+}
+
+// IsRevoked implements the Revoker interface.
+func (r *MemoryRevoker) IsRevoked(token *ValidatedToken) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.index.revoked(token), nil
+}
+
+// FileRevoker is a Revoker reading a RevocationList from a JSON file,
+// reloaded when its modification time changes. The last list read
+// successfully is kept when the file cannot be read or parsed, and the
+// error is passed to OnReloadError.
+type FileRevoker struct {
+	path     string
+	interval time.Duration
+	clock    Clock
+	// OnReloadError is called with the errors of the reloads of the file.
+	// It must be set before the revoker is used.
+	OnReloadError func(err error)
+
+	// mu guards index, modTime and checkedAt,
+	// and reloading is set while a reload is in progress.
+	mu        sync.RWMutex
+	modTime   time.Time
+	checkedAt time.Time
+	index     *revocationIndex
+	reloading int32
+}
+
+// NewFileRevoker creates a FileRevoker reading the revocation list at path,
+// and checking at most every interval whether the file changed.
+func NewFileRevoker(path string, interval time.Duration) (*FileRevoker, error) {
+	return NewFileRevokerWithClock(path, interval, systemClock)
+}
+
+// NewFileRevokerWithClock creates a FileRevoker like NewFileRevoker,
+// measuring the interval between checks with the provided clock.
+func NewFileRevokerWithClock(path string, interval time.Duration, clock Clock) (*FileRevoker, error) {
+	r := &FileRevoker{
+		path:     path,
+		interval: interval,
+		clock:    clock,
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	index, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.index = index
+	r.modTime = info.ModTime()
+	r.checkedAt = clock.Now()
+	return r, nil
+}
+
+// IsRevoked implements the Revoker interface.
+func (r *FileRevoker) IsRevoked(token *ValidatedToken) (bool, error) {
+	r.mu.RLock()
+	index := r.index
+	due := r.clock.Now().Sub(r.checkedAt) >= r.interval
+	r.mu.RUnlock()
+
+	// a single caller reloads the file, the others use the current list
+	if due && atomic.CompareAndSwapInt32(&r.reloading, 0, 1) {
+		if err := r.reload(); err != nil && r.OnReloadError != nil {
+			r.OnReloadError(err)
+		}
+		atomic.StoreInt32(&r.reloading, 0)
+
+		r.mu.RLock()
+		index = r.index
+		r.mu.RUnlock()
+	}
+	return index.revoked(token), nil
+}
+
+// reload loads the file again when its modification time changed.
+func (r *FileRevoker) reload() error {
+	r.mu.Lock()
+	r.checkedAt = r.clock.Now()
+	modTime := r.modTime
+	r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(modTime) {
+		return nil
+	}
+	index, err := r.load()
+	if err != nil {
+		// keep the previous list, the file is loaded again at the next check
+		return err
+	}
+
+	r.mu.Lock()
+	r.index = index
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *FileRevoker) load() (*revocationIndex, error) {
+	b, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	list := RevocationList{}
+	if err = json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	return newRevocationIndex(list), nil
+}
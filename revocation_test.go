@@ -0,0 +1,198 @@
+package auth0
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestMemoryRevoker(t *testing.T) {
+	issuedAt := time.Now().Add(-time.Hour)
+	claims := map[string]interface{}{
+		"iss":       defaultIssuer,
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"iat":       issuedAt.Unix(),
+		"jti":       "token-1",
+		"sub":       "user-1",
+		"client_id": "client-1",
+		"azp":       "spa-1",
+	}
+
+	tests := []struct {
+		name    string
+		revoke  func(r *MemoryRevoker)
+		revoked bool
+	}{
+		{
+			name:   "pass - nothing revoked",
+			revoke: func(r *MemoryRevoker) {},
+		},
+		{
+			name:    "fail - revoked ID",
+			revoke:  func(r *MemoryRevoker) { r.RevokeID("token-1") },
+			revoked: true,
+		},
+		{
+			name:    "fail - revoked subject",
+			revoke:  func(r *MemoryRevoker) { r.RevokeSubject("user-1") },
+			revoked: true,
+		},
+		{
+			name:    "fail - revoked client_id",
+			revoke:  func(r *MemoryRevoker) { r.RevokeClient("client-1") },
+			revoked: true,
+		},
+		{
+			name:    "fail - revoked azp",
+			revoke:  func(r *MemoryRevoker) { r.RevokeClient("spa-1") },
+			revoked: true,
+		},
+		{
+			name:    "fail - issued before the revocation of the subject",
+			revoke:  func(r *MemoryRevoker) { r.RevokeIssuedBefore("user-1", issuedAt.Add(time.Minute)) },
+			revoked: true,
+		},
+		{
+			name:   "pass - issued after the revocation of the subject",
+			revoke: func(r *MemoryRevoker) { r.RevokeIssuedBefore("user-1", issuedAt.Add(-time.Minute)) },
+		},
+		{
+			name: "pass - other tokens revoked",
+			revoke: func(r *MemoryRevoker) {
+				r.RevokeID("token-2")
+				r.RevokeSubject("user-2")
+				r.RevokeClient("client-2")
+				r.RevokeIssuedBefore("user-2", time.Now())
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			revoker := NewMemoryRevoker(RevocationList{})
+			test.revoke(revoker)
+			configuration := NewConfigurationWithOptions(defaultSecretProvider, WithIssuer(defaultIssuer), WithRevokers(revoker))
+			token, err := jwt.ParseSigned(getTestTokenWithClaims(claims, jose.HS256, defaultSecret))
+			assert.Nil(t, err)
+
+			err = NewValidator(configuration, nil).ValidateToken(token)
+			if test.revoked {
+				assert.True(t, errors.Is(err, ErrTokenRevoked), "unexpected error: %v", err)
+				assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonRevoked}))
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestRevokerError(t *testing.T) {
+	errStore := errors.New("store unavailable")
+	configuration := NewConfigurationWithOptions(defaultSecretProvider, WithRevokers(RevokerFunc(func(token *ValidatedToken) (bool, error) {
+		return false, errStore
+	})))
+	token, err := jwt.ParseSigned(getTestToken(defaultAudience, defaultIssuer, time.Now().Add(time.Hour), jose.HS256, defaultSecret))
+	assert.Nil(t, err)
+
+	err = NewValidator(configuration, nil).ValidateToken(token)
+	assert.True(t, errors.Is(err, errStore))
+	assert.True(t, errors.Is(err, &ValidationError{Reason: ReasonRevoked}))
+}
+
+func TestFileRevoker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocations")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revocations.json")
+	write := func(content string, modTime time.Time) {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+		assert.Nil(t, os.Chtimes(path, modTime, modTime))
+	}
+	modTime := time.Now().Add(-time.Hour)
+
+	_, err = NewFileRevoker(filepath.Join(dir, "missing.json"), time.Minute)
+	assert.NotNil(t, err)
+
+	write(`{"jti": ["token-1"]}`, modTime)
+	clock := newFakeClock()
+	revoker, err := NewFileRevokerWithClock(path, time.Minute, clock)
+	assert.Nil(t, err)
+
+	token1 := &ValidatedToken{Claims: jwt.Claims{ID: "token-1", Subject: "user-1"}}
+	token2 := &ValidatedToken{Claims: jwt.Claims{ID: "token-2", Subject: "user-2"}}
+	isRevoked := func(token *ValidatedToken) bool {
+		revoked, err := revoker.IsRevoked(token)
+		assert.Nil(t, err)
+		return revoked
+	}
+	assert.True(t, isRevoked(token1))
+	assert.False(t, isRevoked(token2))
+
+	// the file is not checked again before the interval
+	write(`{"sub": ["user-2"]}`, modTime.Add(time.Minute))
+	assert.True(t, isRevoked(token1))
+	assert.False(t, isRevoked(token2))
+
+	clock.Add(time.Minute)
+	assert.False(t, isRevoked(token1))
+	assert.True(t, isRevoked(token2))
+
+	// the last list read is kept when the file is invalid, and the error reported
+	var reloadErrors []error
+	revoker.OnReloadError = func(err error) { reloadErrors = append(reloadErrors, err) }
+	write(`{"sub": `, modTime.Add(2*time.Minute))
+	clock.Add(time.Minute)
+	assert.False(t, isRevoked(token1))
+	assert.True(t, isRevoked(token2))
+	assert.Len(t, reloadErrors, 1)
+
+	// the invalid file is read again at the next check
+	clock.Add(time.Minute)
+	assert.True(t, isRevoked(token2))
+	assert.Len(t, reloadErrors, 2)
+
+	write(`{"jti": ["token-2"]}`, modTime.Add(3*time.Minute))
+	clock.Add(time.Minute)
+	assert.False(t, isRevoked(token1))
+	assert.True(t, isRevoked(token2))
+	assert.Len(t, reloadErrors, 2)
+
+	os.Remove(path)
+	clock.Add(time.Minute)
+	assert.True(t, isRevoked(token2))
+	assert.Len(t, reloadErrors, 3)
+}
+
+func TestFileRevokerConcurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revocations")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revocations.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"jti": ["token-1"]}`), 0600))
+
+	revoker, err := NewFileRevoker(path, 0)
+	assert.Nil(t, err)
+
+	token := &ValidatedToken{Claims: jwt.Claims{ID: "token-1"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				revoked, err := revoker.IsRevoked(token)
+				assert.Nil(t, err)
+				assert.True(t, revoked)
+			}
+		}()
+	}
+	wg.Wait()
+}
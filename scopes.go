@@ -0,0 +1,88 @@
+package auth0
+
+import (
+	"net/http"
+	"strings"
+)
+
+// InsufficientScopeError is returned by the scope and permission
+// authorizers when the token lacks what the request requires.
+type InsufficientScopeError struct {
+	// Required lists the scopes or permissions the request requires.
+	Required []string
+}
+
+func (e *InsufficientScopeError) Error() string {
+	return "insufficient scope, requires: " + strings.Join(e.Required, " ")
+}
+
+// Scopes returns the scopes of the space-delimited scope claim.
+func Scopes(claims map[string]interface{}) []string {
+	scope, _ := claims["scope"].(string)
+	return strings.Fields(scope)
+}
+
+// Permissions returns the permissions of the permissions
+// claim added by Auth0 RBAC.
+func Permissions(claims map[string]interface{}) []string {
+	values, _ := claims["permissions"].([]interface{})
+	permissions := make([]string, 0, len(values))
+	for _, value := range values {
+		if permission, ok := value.(string); ok {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// RequireScopes returns an Authorizer granting access
+// to tokens holding all of the provided scopes.
+func RequireScopes(scopes ...string) Authorizer {
+	return AuthorizerFunc(func(_ *http.Request, claims map[string]interface{}) error {
+		if !containsAll(Scopes(claims), scopes) {
+			return &InsufficientScopeError{Required: scopes}
+		}
+		return nil
+	})
+}
+
+// RequireAnyScope returns an Authorizer granting access
+// to tokens holding at least one of the provided scopes.
+func RequireAnyScope(scopes ...string) Authorizer {
+	return AuthorizerFunc(func(_ *http.Request, claims map[string]interface{}) error {
+		granted := Scopes(claims)
+		for _, scope := range scopes {
+			if containsAll(granted, []string{scope}) {
+				return nil
+			}
+		}
+		return &InsufficientScopeError{Required: scopes}
+	})
+}
+
+// RequirePermissions returns an Authorizer granting access
+// to tokens holding all of the provided permissions.
+func RequirePermissions(permissions ...string) Authorizer {
+	return AuthorizerFunc(func(_ *http.Request, claims map[string]interface{}) error {
+		if !containsAll(Permissions(claims), permissions) {
+			return &InsufficientScopeError{Required: permissions}
+		}
+		return nil
+	})
+}
+
+func containsAll(values []string, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, v := range values {
+			if v == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package auth0
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestScopeAuthorizers(t *testing.T) {
+	claims := map[string]interface{}{
+		"scope":       "read:news write:news",
+		"permissions": []interface{}{"delete:news", 42},
+	}
+
+	tests := []struct {
+		name          string
+		authorizer    Authorizer
+		expectedError bool
+	}{
+		{"pass - all scopes", RequireScopes("read:news", "write:news"), false},
+		{"pass - no scope required", RequireScopes(), false},
+		{"fail - missing scope", RequireScopes("read:news", "admin"), true},
+		{"pass - any scope", RequireAnyScope("admin", "write:news"), false},
+		{"fail - none of the scopes", RequireAnyScope("admin", "delete:news"), true},
+		{"pass - permissions", RequirePermissions("delete:news"), false},
+		{"fail - missing permission", RequirePermissions("delete:news", "read:news"), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.authorizer.Authorize(nil, claims)
+			if test.expectedError {
+				assert.IsType(t, &InsufficientScopeError{}, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	assert.Empty(t, Scopes(map[string]interface{}{}))
+	assert.Empty(t, Permissions(map[string]interface{}{"permissions": "not an array"}))
+}
+
+func TestMiddlewareInsufficientScope(t *testing.T) {
+	configuration := NewConfiguration(defaultSecretProvider, defaultAudience, defaultIssuer, jose.HS256)
+	token := getTestTokenWithClaims(map[string]interface{}{
+		"iss":   defaultIssuer,
+		"aud":   defaultAudience,
+		"exp":   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		"scope": "read:news",
+	}, jose.HS256, defaultSecret)
+
+	tests := []struct {
+		name                    string
+		authorizer              Authorizer
+		expectedStatus          int
+		expectedWWWAuthenticate string
+	}{
+		{
+			name:           "pass - scope granted",
+			authorizer:     RequireScopes("read:news"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:                    "fail - scope missing",
+			authorizer:              RequireScopes("read:news", "write:news"),
+			expectedStatus:          http.StatusForbidden,
+			expectedWWWAuthenticate: `Bearer error="insufficient_scope", scope="read:news write:news"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := NewValidator(configuration, nil)
+			handler := validator.Middleware(WithAuthorizers(test.authorizer))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest("GET", "http://localhost", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.expectedStatus, rec.Code)
+			assert.Equal(t, test.expectedWWWAuthenticate, rec.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
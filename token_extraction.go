@@ -50,6 +50,8 @@ func FromMultiple(extractors ...RequestTokenExtractor) RequestTokenExtractor {
 // FromHeader looks for the request in the
 // authentication header or call ParseMultipartForm
 // if not present.
+// Both the Bearer and the DPoP schemes are accepted, the validators
+// rejecting the DPoP scheme unless configured WithDPoP.
 // TODO: Implement parsing form data.
 func FromHeader(r *http.Request) (*jwt.JSONWebToken, error) {
 	if r == nil {
@@ -58,11 +60,13 @@ func FromHeader(r *http.Request) (*jwt.JSONWebToken, error) {
 	raw := ""
 	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[0:7], "BEARER ") {
 		raw = h[7:]
+	} else if token, ok := dpopAccessToken(r); ok {
+		raw = token
 	}
 	if raw == "" {
 		return nil, ErrTokenNotFound
 	}
-	return jwt.ParseSigned(raw)
+	return parseToken(r, raw)
 }
 
 // FromParams returns the JWT when passed as the URL query param "token".
@@ -74,7 +78,7 @@ func FromParams(r *http.Request) (*jwt.JSONWebToken, error) {
 	if raw == "" {
 		return nil, ErrTokenNotFound
 	}
-	return jwt.ParseSigned(raw)
+	return parseToken(r, raw)
 }
 
 // FromCookie returns the JWT when passed in a Cookie as "access_token".
@@ -83,5 +87,5 @@ func FromCookie(r *http.Request) (*jwt.JSONWebToken, error) {
 	if err != nil {
 		return nil, ErrTokenNotFound
 	}
-	return jwt.ParseSigned(raw.Value)
+	return parseToken(r, raw.Value)
 }
@@ -134,6 +134,10 @@ func TestFromHeader(t *testing.T) {
 	referenceToken := getTestToken(defaultAudience, defaultIssuer, time.Now(), jose.HS256, defaultSecret)
 	headerValue := fmt.Sprintf("Bearer %s", referenceToken)
 	tReq.Header.Add("Authorization", headerValue)
+	dpopReq := httptest.NewRequest("", "https://", nil)
+	dpopReq.Header.Add("Authorization", "DPoP "+referenceToken)
+	basicReq := httptest.NewRequest("", "https://", nil)
+	basicReq.Header.Add("Authorization", "Basic dXNlcjpwYXNz")
 
 	type args struct {
 		r *http.Request
@@ -144,6 +148,8 @@ func TestFromHeader(t *testing.T) {
 		wantErr bool
 	}{
 		{"valid request", args{tReq}, false},
+		{"valid DPoP request", args{dpopReq}, false},
+		{"other scheme", args{basicReq}, true},
 		{"nil request", args{nil}, true},
 	}
 	for _, tt := range tests {